@@ -17,19 +17,87 @@
 package main
 
 import (
+	"errors"
+	"sync"
 	"time"
 
-	"go.mau.fi/mautrix-slack/database"
+	"github.com/slack-go/slack"
 	log "maunium.net/go/maulogger/v2"
+
+	"go.mau.fi/mautrix-slack/database"
 )
 
+// backfillRequestInterval is the minimum time between history requests to Slack per team.
+// conversations.history and conversations.replies are tier 3 methods, which allow ~50 requests per minute.
+const backfillRequestInterval = 1200 * time.Millisecond
+
 type BackfillQueue struct {
 	BackfillQuery   *database.BackfillQuery
 	reCheckChannels []chan bool
 	log             log.Logger
+
+	lock         sync.Mutex
+	teamWorkers  map[string]bool
+	rateLimiters map[string]*rateLimiter
+}
+
+type rateLimiter struct {
+	lock sync.Mutex
+	next time.Time
+}
+
+// Wait blocks until the next request is allowed.
+func (rl *rateLimiter) Wait() {
+	rl.lock.Lock()
+	now := time.Now()
+	wait := rl.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	rl.next = now.Add(wait + backfillRequestInterval)
+	rl.lock.Unlock()
+	time.Sleep(wait)
+}
+
+// Delay pushes back all requests after Slack has told us to slow down.
+func (rl *rateLimiter) Delay(retryAfter time.Duration) {
+	rl.lock.Lock()
+	if next := time.Now().Add(retryAfter); next.After(rl.next) {
+		rl.next = next
+	}
+	rl.lock.Unlock()
+}
+
+func (bq *BackfillQueue) getRateLimiter(teamID string) *rateLimiter {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+	limiter, ok := bq.rateLimiters[teamID]
+	if !ok {
+		limiter = &rateLimiter{}
+		bq.rateLimiters[teamID] = limiter
+	}
+	return limiter
+}
+
+// CallWithRateLimit calls fn once the team's rate limit allows it, and retries it if Slack still
+// responds with a rate limit error.
+func (bq *BackfillQueue) CallWithRateLimit(teamID string, fn func() error) error {
+	limiter := bq.getRateLimiter(teamID)
+	for {
+		limiter.Wait()
+		err := fn()
+		var rateLimitErr *slack.RateLimitedError
+		if !errors.As(err, &rateLimitErr) {
+			return err
+		}
+		bq.log.Debugfln("Rate limited by Slack in team %s, retrying after %s", teamID, rateLimitErr.RetryAfter)
+		limiter.Delay(rateLimitErr.RetryAfter)
+	}
 }
 
 func (bq *BackfillQueue) ReCheck() {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
 	bq.log.Infofln("Sending re-checks to %d channels", len(bq.reCheckChannels))
 	for _, channel := range bq.reCheckChannels {
 		go func(c chan bool) {
@@ -38,11 +106,29 @@ func (bq *BackfillQueue) ReCheck() {
 	}
 }
 
-func (bq *BackfillQueue) GetNextBackfill(reCheckChannel chan bool) *database.BackfillState {
+func (bq *BackfillQueue) addReCheckChannel() chan bool {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+	reCheckChannel := make(chan bool)
+	bq.reCheckChannels = append(bq.reCheckChannels, reCheckChannel)
+	return reCheckChannel
+}
+
+func (bq *BackfillQueue) getNextBackfillForTeam(teamID string) *database.BackfillState {
+	// Multiple workers share each team, so make sure they don't pick the same portal
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+	backfill := bq.BackfillQuery.GetNextUnfinishedBackfillState(teamID)
+	if backfill != nil {
+		backfill.SetDispatched(true)
+	}
+	return backfill
+}
+
+func (bq *BackfillQueue) GetNextBackfill(teamID string, reCheckChannel chan bool) *database.BackfillState {
 	for {
-		if backfill := bq.BackfillQuery.GetNextUnfinishedBackfillState(); backfill != nil {
+		if backfill := bq.getNextBackfillForTeam(teamID); backfill != nil {
 			bq.log.Debugfln("Found unfinished backfill state for %s", backfill.Portal)
-			backfill.SetDispatched(true)
 			return backfill
 		}
 
@@ -53,17 +139,49 @@ func (bq *BackfillQueue) GetNextBackfill(reCheckChannel chan bool) *database.Bac
 	}
 }
 
+// HandleBackfillRequestsLoop starts worker pools for teams that have unfinished backfills.
 func (bridge *SlackBridge) HandleBackfillRequestsLoop() {
-	reCheckChannel := make(chan bool)
-	bridge.BackfillQueue.reCheckChannels = append(bridge.BackfillQueue.reCheckChannels, reCheckChannel)
+	reCheckChannel := bridge.BackfillQueue.addReCheckChannel()
+
+	for {
+		for _, teamID := range bridge.DB.Backfill.GetTeamsWithUnfinishedBackfills() {
+			bridge.BackfillQueue.startTeamWorkers(bridge, teamID)
+		}
+
+		select {
+		case <-reCheckChannel:
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (bq *BackfillQueue) startTeamWorkers(bridge *SlackBridge, teamID string) {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+	if bq.teamWorkers[teamID] {
+		return
+	}
+	bq.teamWorkers[teamID] = true
+
+	workers := bridge.Config.Bridge.Backfill.WorkersPerTeam
+	if workers < 1 {
+		workers = 1
+	}
+	bq.log.Debugfln("Starting %d backfill workers for team %s", workers, teamID)
+	for i := 0; i < workers; i++ {
+		go bridge.handleTeamBackfillRequests(teamID)
+	}
+}
+
+func (bridge *SlackBridge) handleTeamBackfillRequests(teamID string) {
+	reCheckChannel := bridge.BackfillQueue.addReCheckChannel()
 
 	for {
-		state := bridge.BackfillQueue.GetNextBackfill(reCheckChannel)
+		state := bridge.BackfillQueue.GetNextBackfill(teamID, reCheckChannel)
 		bridge.Log.Infofln("Handling backfill for portal %s", state.Portal)
 
 		portal := bridge.GetPortalByID(*state.Portal)
 
 		bridge.backfillInChunks(state, portal)
-		//req.MarkDone()
 	}
 }
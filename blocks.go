@@ -1,19 +1,24 @@
 package main
 
 import (
+	"container/list"
 	"fmt"
 	"html"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/yuin/goldmark"
 	"go.mau.fi/mautrix-slack/database"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
 )
 
 func (portal *Portal) renderImageBlock(block slack.ImageBlock) (*event.MessageEventContent, error) {
@@ -49,7 +54,7 @@ func (portal *Portal) renderImageBlock(block slack.ImageBlock) (*event.MessageEv
 	return &content, nil
 }
 
-func (portal *Portal) mrkdwnToMatrixHtml(mrkdwn string) string {
+func (portal *Portal) mrkdwnToMatrixHtml(mrkdwn string, userTeam *database.UserTeam) string {
 	mrkdwn = replaceShortcodesWithEmojis(mrkdwn)
 
 	mrkdwn = escapeFixer.ReplaceAllStringFunc(mrkdwn, func(s string) string {
@@ -58,7 +63,7 @@ func (portal *Portal) mrkdwnToMatrixHtml(mrkdwn string) string {
 
 	mdRenderer := goldmark.New(
 		format.Extensions, format.HTMLOptions,
-		goldmark.WithExtensions(&SlackTag{portal}),
+		goldmark.WithExtensions(&SlackTag{portal, userTeam}),
 	)
 
 	var buf strings.Builder
@@ -67,11 +72,11 @@ func (portal *Portal) mrkdwnToMatrixHtml(mrkdwn string) string {
 	return format.UnwrapSingleParagraph(buf.String())
 }
 
-func (portal *Portal) renderSlackTextBlock(block slack.TextBlockObject) string {
+func (portal *Portal) renderSlackTextBlock(block slack.TextBlockObject, userTeam *database.UserTeam) string {
 	if block.Type == slack.PlainTextType {
 		return html.EscapeString(html.UnescapeString(block.Text))
 	} else if block.Type == slack.MarkdownType {
-		return portal.mrkdwnToMatrixHtml(block.Text)
+		return portal.mrkdwnToMatrixHtml(block.Text, userTeam)
 	} else {
 		return ""
 	}
@@ -149,7 +154,7 @@ func (portal *Portal) renderRichTextSectionElements(elements []slack.RichTextSec
 			} else {
 				emoji := portal.bridge.GetEmoji(e.Name, userTeam)
 				if strings.HasPrefix(emoji, "mxc://") {
-					htmlText.WriteString(fmt.Sprintf(`<img data-mx-emoticon src="%[1]s" alt="%[2]s" title="%[2]s" height="32"/>`, emoji, e.Name))
+					htmlText.WriteString(customEmojiHTML(emoji, e.Name))
 				} else if emoji != e.Name {
 					htmlText.WriteString(emoji)
 				} else {
@@ -167,25 +172,377 @@ func (portal *Portal) renderRichTextSectionElements(elements []slack.RichTextSec
 	return htmlText.String()
 }
 
+const (
+	blockTimeFormat = "2006-01-02 15:04 MST"
+	// iconHeight is the height of small images like context and author icons
+	iconHeight = 16
+	// thumbnailHeight is the height of attachment thumbnails
+	thumbnailHeight = 75
+)
+
+// maxCachedBlockImages is the number of reuploaded block images that are remembered.
+const maxCachedBlockImages = 1024
+
+type blockImageUpload struct {
+	done chan struct{}
+	mxc  id.ContentURI
+	err  error
+}
+
+type cachedBlockImage struct {
+	url string
+	mxc id.ContentURI
+}
+
+// blockImageCache is a least recently used cache of reuploaded block images. Concurrent requests for
+// the same image wait for a single upload instead of all reuploading it.
+type blockImageCache struct {
+	lock     sync.Mutex
+	size     int
+	entries  map[string]*list.Element
+	order    *list.List
+	inFlight map[string]*blockImageUpload
+}
+
+func newBlockImageCache(size int) *blockImageCache {
+	return &blockImageCache{
+		size:     size,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inFlight: make(map[string]*blockImageUpload),
+	}
+}
+
+func (cache *blockImageCache) Get(imageURL string, upload func() (id.ContentURI, error)) (id.ContentURI, error) {
+	cache.lock.Lock()
+	if elem, ok := cache.entries[imageURL]; ok {
+		cache.order.MoveToFront(elem)
+		cache.lock.Unlock()
+		return elem.Value.(*cachedBlockImage).mxc, nil
+	} else if current, ok := cache.inFlight[imageURL]; ok {
+		cache.lock.Unlock()
+		<-current.done
+		return current.mxc, current.err
+	}
+	current := &blockImageUpload{done: make(chan struct{})}
+	cache.inFlight[imageURL] = current
+	cache.lock.Unlock()
+
+	current.mxc, current.err = upload()
+
+	cache.lock.Lock()
+	delete(cache.inFlight, imageURL)
+	if current.err == nil {
+		cache.entries[imageURL] = cache.order.PushFront(&cachedBlockImage{url: imageURL, mxc: current.mxc})
+		if cache.order.Len() > cache.size {
+			oldest := cache.order.Remove(cache.order.Back()).(*cachedBlockImage)
+			delete(cache.entries, oldest.url)
+		}
+	}
+	cache.lock.Unlock()
+	close(current.done)
+	return current.mxc, current.err
+}
+
+// uploadBlockImage reuploads an image referenced by a Slack block to Matrix. Bots tend to send the same
+// icons over and over again, so the resulting content URIs are cached in memory by source URL.
+const maxBlockImageSize = 10 * 1024 * 1024
+
+// blockImageClient is used for downloading block images, which can point at any server,
+// so that a slow or misbehaving server can't hold up the event handling forever.
+var blockImageClient = &http.Client{Timeout: 30 * time.Second}
+
+func (portal *Portal) uploadBlockImage(imageURL string) (id.ContentURI, error) {
+	return portal.bridge.blockImages.Get(imageURL, func() (id.ContentURI, error) {
+		resp, err := blockImageClient.Get(imageURL)
+		if err != nil {
+			return id.ContentURI{}, fmt.Errorf("failed to download image: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return id.ContentURI{}, fmt.Errorf("failed to download image: unexpected status %s", resp.Status)
+		}
+		mimeType := resp.Header.Get("Content-Type")
+		if !strings.HasPrefix(mimeType, "image/") {
+			return id.ContentURI{}, fmt.Errorf("unexpected content type %q", mimeType)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBlockImageSize+1))
+		if err != nil {
+			return id.ContentURI{}, fmt.Errorf("failed to read image data: %w", err)
+		} else if len(data) > maxBlockImageSize {
+			return id.ContentURI{}, fmt.Errorf("image is larger than %d bytes", maxBlockImageSize)
+		}
+		uploadResp, err := portal.MainIntent().UploadBytes(data, mimeType)
+		if err != nil {
+			return id.ContentURI{}, fmt.Errorf("failed to upload image to Matrix: %w", err)
+		}
+		return uploadResp.ContentURI, nil
+	})
+}
+
+// renderBlockImage reuploads the given image and renders it as an inline image, optionally scaled down
+// to the given height. If the image can't be reuploaded, a link to the original is rendered instead.
+func (portal *Portal) renderBlockImage(imageURL, altText string, height int) string {
+	if altText == "" {
+		altText = path.Base(imageURL)
+	}
+	mxc, err := portal.uploadBlockImage(imageURL)
+	if err != nil {
+		portal.log.Warnfln("Failed to reupload Slack block image %s: %v", imageURL, err)
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(imageURL), html.EscapeString(altText))
+	}
+	var size string
+	if height > 0 {
+		size = fmt.Sprintf(` height="%d"`, height)
+	}
+	return fmt.Sprintf(`<img src="%s" alt="%s" title="%s"%s>`, mxc, html.EscapeString(altText), html.EscapeString(altText), size)
+}
+
+func (portal *Portal) renderOptionalTextBlock(block *slack.TextBlockObject, userTeam *database.UserTeam) string {
+	if block == nil {
+		return ""
+	}
+	return portal.renderSlackTextBlock(*block, userTeam)
+}
+
+func (portal *Portal) renderBlockOption(option *slack.OptionBlockObject, userTeam *database.UserTeam) string {
+	if option == nil {
+		return ""
+	}
+	text := portal.renderOptionalTextBlock(option.Text, userTeam)
+	if option.URL != "" {
+		text = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(option.URL), text)
+	}
+	if option.Description != nil {
+		text = fmt.Sprintf("%s <i>(%s)</i>", text, portal.renderSlackTextBlock(*option.Description, userTeam))
+	}
+	return text
+}
+
+func (portal *Portal) renderBlockOptions(options []*slack.OptionBlockObject, userTeam *database.UserTeam) string {
+	rendered := make([]string, 0, len(options))
+	for _, option := range options {
+		rendered = append(rendered, portal.renderBlockOption(option, userTeam))
+	}
+	return strings.Join(rendered, ", ")
+}
+
+func (portal *Portal) renderBlockChoices(options, selected []*slack.OptionBlockObject, userTeam *database.UserTeam) string {
+	var htmlText strings.Builder
+	htmlText.WriteString("<ul>")
+	for _, option := range options {
+		mark := "☐"
+		for _, selectedOption := range selected {
+			if selectedOption != nil && option != nil && selectedOption.Value == option.Value {
+				mark = "☑"
+				break
+			}
+		}
+		htmlText.WriteString(fmt.Sprintf("<li>%s %s</li>", mark, portal.renderBlockOption(option, userTeam)))
+	}
+	htmlText.WriteString("</ul>")
+	return htmlText.String()
+}
+
+// renderBlockInputValue renders the value of an input-like element, falling back to the placeholder
+// when the input hasn't been filled in.
+func (portal *Portal) renderBlockInputValue(value string, placeholder *slack.TextBlockObject, userTeam *database.UserTeam) string {
+	if value != "" {
+		return fmt.Sprintf("<code>%s</code>", html.EscapeString(value))
+	} else if placeholder != nil {
+		return fmt.Sprintf("<i>%s</i>", portal.renderSlackTextBlock(*placeholder, userTeam))
+	} else {
+		return "<i>empty</i>"
+	}
+}
+
+func (portal *Portal) renderBlockElement(element slack.BlockElement, userTeam *database.UserTeam) string {
+	switch e := element.(type) {
+	case *slack.ImageBlockElement:
+		return portal.renderBlockImage(e.ImageURL, e.AltText, 0)
+	case *slack.ButtonBlockElement:
+		label := portal.renderOptionalTextBlock(e.Text, userTeam)
+		if e.URL != "" {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(e.URL), label)
+		}
+		return fmt.Sprintf("<b>[%s]</b>", label)
+	case *slack.OverflowBlockElement:
+		return portal.renderBlockOptions(e.Options, userTeam)
+	case *slack.SelectBlockElement:
+		if e.InitialOption != nil {
+			return portal.renderBlockOption(e.InitialOption, userTeam)
+		}
+		return portal.renderBlockInputValue("", e.Placeholder, userTeam)
+	case *slack.MultiSelectBlockElement:
+		if len(e.InitialOptions) > 0 {
+			return portal.renderBlockOptions(e.InitialOptions, userTeam)
+		}
+		return portal.renderBlockInputValue("", e.Placeholder, userTeam)
+	case *slack.CheckboxGroupsBlockElement:
+		return portal.renderBlockChoices(e.Options, e.InitialOptions, userTeam)
+	case *slack.RadioButtonsBlockElement:
+		return portal.renderBlockChoices(e.Options, []*slack.OptionBlockObject{e.InitialOption}, userTeam)
+	case *slack.DatePickerBlockElement:
+		return portal.renderBlockInputValue(e.InitialDate, e.Placeholder, userTeam)
+	case *slack.TimePickerBlockElement:
+		return portal.renderBlockInputValue(e.InitialTime, e.Placeholder, userTeam)
+	case *slack.DateTimePickerBlockElement:
+		if e.InitialDateTime == 0 {
+			return portal.renderBlockInputValue("", nil, userTeam)
+		}
+		return portal.renderBlockInputValue(time.Unix(e.InitialDateTime, 0).UTC().Format(blockTimeFormat), nil, userTeam)
+	case *slack.PlainTextInputBlockElement:
+		return portal.renderBlockInputValue(e.InitialValue, e.Placeholder, userTeam)
+	case *slack.EmailTextInputBlockElement:
+		return portal.renderBlockInputValue(e.InitialValue, e.Placeholder, userTeam)
+	case *slack.URLTextInputBlockElement:
+		return portal.renderBlockInputValue(e.InitialValue, e.Placeholder, userTeam)
+	case *slack.NumberInputBlockElement:
+		return portal.renderBlockInputValue(e.InitialValue, e.Placeholder, userTeam)
+	default:
+		portal.log.Debugfln("Unsupported Slack block element: %s", element.ElementType())
+		return "<i>Unsupported element</i>"
+	}
+}
+
+func accessoryElement(accessory *slack.Accessory) slack.BlockElement {
+	switch {
+	case accessory.ImageElement != nil:
+		return accessory.ImageElement
+	case accessory.ButtonElement != nil:
+		return accessory.ButtonElement
+	case accessory.OverflowElement != nil:
+		return accessory.OverflowElement
+	case accessory.DatePickerElement != nil:
+		return accessory.DatePickerElement
+	case accessory.TimePickerElement != nil:
+		return accessory.TimePickerElement
+	case accessory.PlainTextInputElement != nil:
+		return accessory.PlainTextInputElement
+	case accessory.RadioButtonsElement != nil:
+		return accessory.RadioButtonsElement
+	case accessory.SelectElement != nil:
+		return accessory.SelectElement
+	case accessory.MultiSelectElement != nil:
+		return accessory.MultiSelectElement
+	case accessory.CheckboxGroupsBlockElement != nil:
+		return accessory.CheckboxGroupsBlockElement
+	case accessory.UnknownElement != nil:
+		return accessory.UnknownElement
+	default:
+		return nil
+	}
+}
+
+func (portal *Portal) renderSectionBlock(block *slack.SectionBlock, userTeam *database.UserTeam) string {
+	var htmlText strings.Builder
+	if block.Text != nil {
+		htmlText.WriteString(portal.renderSlackTextBlock(*block.Text, userTeam))
+	}
+	if len(block.Fields) > 0 {
+		// Slack renders fields in two columns, so emulate that with a table
+		htmlText.WriteString("<table>")
+		for i := 0; i < len(block.Fields); i += 2 {
+			htmlText.WriteString("<tr>")
+			htmlText.WriteString(fmt.Sprintf("<td>%s</td>", portal.renderOptionalTextBlock(block.Fields[i], userTeam)))
+			if i+1 < len(block.Fields) {
+				htmlText.WriteString(fmt.Sprintf("<td>%s</td>", portal.renderOptionalTextBlock(block.Fields[i+1], userTeam)))
+			}
+			htmlText.WriteString("</tr>")
+		}
+		htmlText.WriteString("</table>")
+	}
+	if block.Accessory != nil {
+		if element := accessoryElement(block.Accessory); element != nil {
+			if htmlText.Len() > 0 {
+				htmlText.WriteString("<br>")
+			}
+			htmlText.WriteString(portal.renderBlockElement(element, userTeam))
+		}
+	}
+	return htmlText.String()
+}
+
+func (portal *Portal) renderContextBlock(block *slack.ContextBlock, userTeam *database.UserTeam) string {
+	rendered := make([]string, 0, len(block.ContextElements.Elements))
+	for _, element := range block.ContextElements.Elements {
+		switch e := element.(type) {
+		case *slack.TextBlockObject:
+			rendered = append(rendered, portal.renderSlackTextBlock(*e, userTeam))
+		case *slack.ImageBlockElement:
+			rendered = append(rendered, portal.renderBlockImage(e.ImageURL, e.AltText, iconHeight))
+		}
+	}
+	return fmt.Sprintf("<sub>%s</sub>", strings.Join(rendered, " "))
+}
+
+func (portal *Portal) renderActionBlock(block *slack.ActionBlock, userTeam *database.UserTeam) string {
+	if block.Elements == nil || len(block.Elements.ElementSet) == 0 {
+		return ""
+	}
+	var htmlText strings.Builder
+	htmlText.WriteString("<ul>")
+	for _, element := range block.Elements.ElementSet {
+		htmlText.WriteString(fmt.Sprintf("<li>%s</li>", portal.renderBlockElement(element, userTeam)))
+	}
+	htmlText.WriteString("</ul>")
+	return htmlText.String()
+}
+
+func (portal *Portal) renderInputBlock(block *slack.InputBlock, userTeam *database.UserTeam) string {
+	var htmlText strings.Builder
+	htmlText.WriteString(fmt.Sprintf("<b>%s</b>", portal.renderOptionalTextBlock(block.Label, userTeam)))
+	if block.Optional {
+		htmlText.WriteString(" <i>(optional)</i>")
+	}
+	if block.Element != nil {
+		htmlText.WriteString("<br>")
+		htmlText.WriteString(portal.renderBlockElement(block.Element, userTeam))
+	}
+	if block.Hint != nil {
+		htmlText.WriteString(fmt.Sprintf("<br><sub>%s</sub>", portal.renderSlackTextBlock(*block.Hint, userTeam)))
+	}
+	return htmlText.String()
+}
+
 func (portal *Portal) renderSlackBlock(block slack.Block, userTeam *database.UserTeam) (string, bool) {
 	switch b := block.(type) {
 	case *slack.HeaderBlock:
-		return fmt.Sprintf("<h1>%s</h1>", portal.renderSlackTextBlock(*b.Text)), false
+		return fmt.Sprintf("<h1>%s</h1>", portal.renderSlackTextBlock(*b.Text, userTeam)), false
 	case *slack.DividerBlock:
 		return "<hr>", false
 	case *slack.SectionBlock:
-		if b.Text != nil {
-			return portal.renderSlackTextBlock(*b.Text), false
-		} else {
-			portal.log.Debugln("Unsupported Slack block: section block without a text object")
-			return "<i>Slack message contains unsupported elements.</i>", true
+		return portal.renderSectionBlock(b, userTeam), false
+	case *slack.ContextBlock:
+		return portal.renderContextBlock(b, userTeam), false
+	case *slack.ActionBlock:
+		return portal.renderActionBlock(b, userTeam), false
+	case *slack.InputBlock:
+		return portal.renderInputBlock(b, userTeam), false
+	case *slack.ImageBlock:
+		var title string
+		if b.Title != nil {
+			title = fmt.Sprintf("<b>%s</b><br>", portal.renderSlackTextBlock(*b.Title, userTeam))
 		}
+		return title + portal.renderBlockImage(b.ImageURL, b.AltText, 0), false
+	case *slack.FileBlock:
+		return fmt.Sprintf("<i>Shared a %s file: %s</i>", html.EscapeString(b.Source), html.EscapeString(b.ExternalID)), false
 	case *slack.RichTextBlock:
 		var htmlText strings.Builder
 		for _, element := range b.Elements {
 			htmlText.WriteString(portal.renderSlackRichTextElement(len(b.Elements), element, userTeam))
 		}
 		return format.UnwrapSingleParagraph(htmlText.String()), false
+	case *slack.UnknownBlock:
+		// slack-go doesn't parse the contents of these, so there's nothing more to show than a hint
+		switch b.Type {
+		case "video":
+			return "<i>Shared a video, open Slack to watch it.</i>", false
+		case "call":
+			return "<i>Started a call, open Slack to join it.</i>", false
+		}
+		portal.log.Debugfln("Unsupported Slack block: %s", b.Type)
+		return "<i>Slack message contains unsupported elements.</i>", true
 	default:
 		portal.log.Debugfln("Unsupported Slack block: %s", b.BlockType())
 		return "<i>Slack message contains unsupported elements.</i>", true
@@ -252,7 +609,132 @@ func (portal *Portal) blocksToHtml(blocks slack.Blocks, alwaysWrap bool, userTea
 	return htmlText.String()
 }
 
-func (portal *Portal) SlackBlocksToMatrix(blocks slack.Blocks, attachments []slack.Attachment, userTeam *database.UserTeam) (*event.MessageEventContent, error) {
+// attachmentColors maps the named colours Slack accepts in legacy attachments to their hex values.
+var attachmentColors = map[string]string{
+	"good":    "#2eb886",
+	"warning": "#daa038",
+	"danger":  "#a30200",
+}
+
+func attachmentColor(color string) string {
+	if hex, ok := attachmentColors[color]; ok {
+		return hex
+	} else if color == "" {
+		return ""
+	} else if !strings.HasPrefix(color, "#") {
+		color = "#" + color
+	}
+	if _, err := strconv.ParseUint(color[1:], 16, 32); err != nil || len(color) != 7 {
+		return ""
+	}
+	return color
+}
+
+func (portal *Portal) renderAttachmentFields(fields []slack.AttachmentField, userTeam *database.UserTeam) string {
+	var htmlText strings.Builder
+	htmlText.WriteString("<table>")
+	renderField := func(field slack.AttachmentField, colspan string) {
+		htmlText.WriteString(fmt.Sprintf("<td%s>", colspan))
+		if field.Title != "" {
+			htmlText.WriteString(fmt.Sprintf("<b>%s</b><br>", html.EscapeString(html.UnescapeString(field.Title))))
+		}
+		htmlText.WriteString(portal.mrkdwnToMatrixHtml(field.Value, userTeam))
+		htmlText.WriteString("</td>")
+	}
+	for i := 0; i < len(fields); i++ {
+		htmlText.WriteString("<tr>")
+		// Short fields are shown side by side, long fields take up the whole row
+		if fields[i].Short && i+1 < len(fields) && fields[i+1].Short {
+			renderField(fields[i], "")
+			renderField(fields[i+1], "")
+			i++
+		} else {
+			renderField(fields[i], ` colspan="2"`)
+		}
+		htmlText.WriteString("</tr>")
+	}
+	htmlText.WriteString("</table>")
+	return htmlText.String()
+}
+
+func (portal *Portal) renderSlackAttachment(attachment slack.Attachment, userTeam *database.UserTeam) string {
+	var htmlText strings.Builder
+	if attachment.Pretext != "" {
+		htmlText.WriteString(fmt.Sprintf("<p>%s</p>", portal.mrkdwnToMatrixHtml(attachment.Pretext, userTeam)))
+	}
+
+	var body strings.Builder
+	authorName, authorIcon, authorLink := attachment.AuthorName, attachment.AuthorIcon, attachment.AuthorLink
+	if authorName == "" && attachment.ServiceName != "" {
+		authorName, authorIcon, authorLink = attachment.ServiceName, attachment.ServiceIcon, attachment.OriginalURL
+	}
+	if authorName != "" {
+		body.WriteString("<p>")
+		if authorIcon != "" {
+			body.WriteString(portal.renderBlockImage(authorIcon, authorName, iconHeight))
+			body.WriteString(" ")
+		}
+		authorHTML := html.EscapeString(html.UnescapeString(authorName))
+		if authorLink != "" {
+			authorHTML = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(authorLink), authorHTML)
+		}
+		body.WriteString(fmt.Sprintf("<b>%s</b>", authorHTML))
+		if attachment.AuthorSubname != "" {
+			body.WriteString(" " + html.EscapeString(html.UnescapeString(attachment.AuthorSubname)))
+		}
+		body.WriteString("</p>")
+	}
+	if attachment.Title != "" {
+		titleHTML := html.EscapeString(html.UnescapeString(attachment.Title))
+		if attachment.TitleLink != "" {
+			titleHTML = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(attachment.TitleLink), titleHTML)
+		}
+		body.WriteString(fmt.Sprintf("<p><b>%s</b></p>", titleHTML))
+	}
+	if attachment.Text != "" {
+		body.WriteString(fmt.Sprintf("<p>%s</p>", portal.mrkdwnToMatrixHtml(attachment.Text, userTeam)))
+	}
+	if len(attachment.Fields) > 0 {
+		body.WriteString(portal.renderAttachmentFields(attachment.Fields, userTeam))
+	}
+	if len(attachment.Blocks.BlockSet) > 0 {
+		body.WriteString(portal.blocksToHtml(attachment.Blocks, true, userTeam))
+	}
+	if attachment.ImageURL != "" {
+		body.WriteString(fmt.Sprintf("<p>%s</p>", portal.renderBlockImage(attachment.ImageURL, attachment.Title, 0)))
+	} else if attachment.ThumbURL != "" {
+		body.WriteString(fmt.Sprintf("<p>%s</p>", portal.renderBlockImage(attachment.ThumbURL, attachment.Title, thumbnailHeight)))
+	}
+	var footer []string
+	if attachment.FooterIcon != "" {
+		footer = append(footer, portal.renderBlockImage(attachment.FooterIcon, attachment.Footer, iconHeight))
+	}
+	if attachment.Footer != "" {
+		footer = append(footer, portal.mrkdwnToMatrixHtml(attachment.Footer, userTeam))
+	}
+	if ts, err := attachment.Ts.Float64(); err == nil && ts > 0 {
+		footer = append(footer, time.Unix(int64(ts), 0).UTC().Format(blockTimeFormat))
+	}
+	if len(footer) > 0 {
+		body.WriteString(fmt.Sprintf("<p><sub>%s</sub></p>", strings.Join(footer, " ")))
+	}
+
+	if body.Len() == 0 {
+		if attachment.Fallback == "" {
+			return htmlText.String()
+		}
+		body.WriteString(portal.mrkdwnToMatrixHtml(attachment.Fallback, userTeam))
+	}
+	if color := attachmentColor(attachment.Color); color != "" {
+		// Matrix has no way to colour the quote border, so add a coloured bar instead
+		htmlText.WriteString(fmt.Sprintf(`<blockquote><font data-mx-color="%s">▍</font>%s</blockquote>`, color, body.String()))
+	} else {
+		htmlText.WriteString(fmt.Sprintf("<blockquote>%s</blockquote>", body.String()))
+	}
+	return htmlText.String()
+}
+
+func (portal *Portal) SlackBlocksToMatrix(text string, blocks slack.Blocks, attachments []slack.Attachment, userTeam *database.UserTeam) (*event.MessageEventContent, error) {
 
 	// Special case for bots like the Giphy bot which send images in a specific format
 	if len(blocks.BlockSet) == 2 &&
@@ -264,7 +746,12 @@ func (portal *Portal) SlackBlocksToMatrix(blocks slack.Blocks, attachments []sla
 
 	var htmlText strings.Builder
 
-	htmlText.WriteString(portal.blocksToHtml(blocks, false, userTeam))
+	if len(blocks.BlockSet) > 0 {
+		htmlText.WriteString(portal.blocksToHtml(blocks, false, userTeam))
+	} else if text != "" {
+		// Messages with legacy attachments don't necessarily have blocks for the main text
+		htmlText.WriteString(portal.mrkdwnToMatrixHtml(text, userTeam))
+	}
 
 	for _, attachment := range attachments {
 		if attachment.IsMsgUnfurl {
@@ -273,9 +760,11 @@ func (portal *Portal) SlackBlocksToMatrix(blocks slack.Blocks, attachments []sla
 				htmlText.WriteString(fmt.Sprintf("<blockquote><b>%s</b><br>%s<a href=\"%s\"><i>%s</i></a><br></blockquote>",
 					attachment.AuthorName, renderedAttachment, attachment.FromURL, attachment.Footer))
 			}
+		} else {
+			htmlText.WriteString(portal.renderSlackAttachment(attachment, userTeam))
 		}
 	}
 
-	content := format.HTMLToContent(htmlText.String())
+	content := htmlToContentWithEmoji(htmlText.String())
 	return &content, nil
 }
@@ -17,13 +17,20 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
+	"maunium.net/go/mautrix/bridge/bridgeconfig"
 	"maunium.net/go/mautrix/bridge/commands"
+
+	"go.mau.fi/mautrix-slack/database"
 )
 
+var HelpSectionPortalManagement = commands.HelpSection{Name: "Portal management", Order: 20}
+
 type WrappedCommandEvent struct {
 	*commands.Event
 	Bridge *SlackBridge
@@ -40,6 +47,13 @@ func (br *SlackBridge) RegisterCommands() {
 		cmdLogout,
 		cmdSyncTeams,
 		cmdDeletePortal,
+		cmdSetRelay,
+		cmdUnsetRelay,
+		cmdImportSlackExport,
+		cmdBackfill,
+		cmdCreate,
+		cmdBridge,
+		cmdUnbridge,
 	)
 }
 
@@ -74,7 +88,7 @@ func fnPing(ce *WrappedCommandEvent) {
 	for _, team := range ce.User.Teams {
 		teamInfo := ce.Bridge.DB.TeamInfo.GetBySlackTeam(team.Key.TeamID)
 		text.WriteString(fmt.Sprintf("%s - %s - %s.slack.com", teamInfo.TeamID, teamInfo.TeamName, teamInfo.TeamDomain))
-		if team.RTM == nil {
+		if !team.IsConnected() {
 			text.WriteString(" (Error: not connected to Slack)")
 		}
 		text.WriteRune('\n')
@@ -197,3 +211,264 @@ func fnDeletePortal(ce *WrappedCommandEvent) {
 	ce.Portal.cleanup(false)
 	ce.Log.Infofln("Deleted portal")
 }
+
+var cmdSetRelay = &commands.FullHandler{
+	Func: wrapCommand(fnSetRelay),
+	Name: "set-relay",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionPortalManagement,
+		Description: "Relay messages in this room through your Slack account.",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+func fnSetRelay(ce *WrappedCommandEvent) {
+	if !ce.Bridge.Config.Bridge.Relay.Enabled {
+		ce.Reply("Relay mode is not enabled on this instance of the bridge")
+	} else if ce.Bridge.Config.Bridge.Relay.AdminOnly && ce.User.PermissionLevel < bridgeconfig.PermissionLevelAdmin {
+		ce.Reply("Only admins are allowed to enable relay mode on this instance of the bridge")
+	} else if ce.User.GetUserTeam(ce.Portal.Key.TeamID) == nil {
+		ce.Reply("You're not logged into the Slack team of this room")
+	} else {
+		ce.Portal.RelayUserID = ce.User.MXID
+		ce.Portal.Update(nil)
+		ce.Reply("Messages from non-logged-in users in this room will now be bridged through your Slack account")
+	}
+}
+
+var cmdUnsetRelay = &commands.FullHandler{
+	Func: wrapCommand(fnUnsetRelay),
+	Name: "unset-relay",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionPortalManagement,
+		Description: "Stop relaying messages in this room.",
+	},
+	RequiresPortal: true,
+}
+
+func fnUnsetRelay(ce *WrappedCommandEvent) {
+	if !ce.Bridge.Config.Bridge.Relay.Enabled {
+		ce.Reply("Relay mode is not enabled on this instance of the bridge")
+	} else if ce.Portal.RelayUserID == "" {
+		ce.Reply("This room does not have a relay user set")
+	} else if ce.Bridge.Config.Bridge.Relay.AdminOnly && ce.User.PermissionLevel < bridgeconfig.PermissionLevelAdmin {
+		ce.Reply("Only admins are allowed to disable relay mode on this instance of the bridge")
+	} else if ce.Portal.RelayUserID != ce.User.MXID && ce.User.PermissionLevel < bridgeconfig.PermissionLevelAdmin {
+		ce.Reply("Only the relay user or bridge admins can disable relay mode in this room")
+	} else {
+		ce.Portal.RelayUserID = ""
+		ce.Portal.Update(nil)
+		ce.Reply("Messages from non-logged-in users will no longer be bridged in this room")
+	}
+}
+
+var cmdImportSlackExport = &commands.FullHandler{
+	Func: wrapCommand(fnImportSlackExport),
+	Name: "import-slack-export",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Import the history in a Slack workspace export ZIP file on the bridge server.",
+		Args:        "<_path to export_> [_team ID_]",
+	},
+	RequiresAdmin: true,
+	RequiresLogin: true,
+}
+
+func fnImportSlackExport(ce *WrappedCommandEvent) {
+	if len(ce.Args) < 1 || len(ce.Args) > 2 {
+		ce.Reply("**Usage**: $cmdprefix import-slack-export <path to export> [team ID]")
+		return
+	}
+	var userTeam *database.UserTeam
+	if len(ce.Args) == 2 {
+		userTeam = ce.User.GetUserTeam(ce.Args[1])
+	} else if teams := ce.User.GetLoggedInTeams(); len(teams) == 1 {
+		userTeam = teams[0]
+	} else {
+		ce.Reply("You're logged into multiple teams, please specify the ID of the exported team")
+		return
+	}
+	if userTeam == nil {
+		ce.Reply("You're not logged into that team")
+		return
+	}
+	go func() {
+		err := ce.User.ImportSlackExport(userTeam, ce.Args[0], ce.Reply)
+		if err != nil {
+			ce.Reply("Failed to import Slack export: %v", err)
+		}
+	}()
+}
+
+var cmdBackfill = &commands.FullHandler{
+	Func: wrapCommand(fnBackfill),
+	Name: "backfill",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionPortalManagement,
+		Description: "Backfill more messages in this room, or retry a backfill that failed.",
+		Args:        "[_number of messages_]",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+func fnBackfill(ce *WrappedCommandEvent) {
+	var count int
+	if len(ce.Args) > 1 {
+		ce.Reply("**Usage**: $cmdprefix backfill [number of messages]")
+		return
+	} else if len(ce.Args) == 1 {
+		var err error
+		count, err = strconv.Atoi(ce.Args[0])
+		if err != nil || count < 1 {
+			ce.Reply("The number of messages must be a positive integer")
+			return
+		}
+	}
+	err := ce.Portal.checkBackfillAccess(ce.User)
+	if err != nil {
+		ce.Reply("Can't backfill: %v", err)
+		return
+	}
+
+	err = ce.Portal.RequestBackfill(count)
+	if errors.Is(err, errBackfillLimitReached) {
+		ce.Reply("This room has already been backfilled up to the configured limit, specify a number of messages to backfill more")
+	} else if err != nil {
+		ce.Reply("Failed to queue backfill: %v", err)
+	} else if count > 0 {
+		ce.Reply("Queued backfill of up to %d messages", count)
+	} else {
+		ce.Reply("Queued backfill")
+	}
+}
+
+// getUserTeamArg finds the user team to use for a command that takes an optional team ID argument.
+func (ce *WrappedCommandEvent) getUserTeamArg(teamID string) *database.UserTeam {
+	var userTeam *database.UserTeam
+	if teamID != "" {
+		userTeam = ce.User.GetUserTeam(teamID)
+	} else if teams := ce.User.GetLoggedInTeams(); len(teams) == 1 {
+		userTeam = teams[0]
+	} else {
+		ce.Reply("You're logged into multiple teams, please specify the team ID")
+		return nil
+	}
+	if userTeam == nil || !userTeam.IsConnected() {
+		ce.Reply("You're not logged into that team")
+		return nil
+	}
+	return userTeam
+}
+
+var cmdCreate = &commands.FullHandler{
+	Func: wrapCommand(fnCreate),
+	Name: "create",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionPortalManagement,
+		Description: "Create a Slack channel for the current Matrix room. The channel is named after the room.",
+		Args:        "<public|private> [_team ID_]",
+	},
+	RequiresLogin: true,
+}
+
+func fnCreate(ce *WrappedCommandEvent) {
+	if len(ce.Args) < 1 || len(ce.Args) > 2 || (ce.Args[0] != "public" && ce.Args[0] != "private") {
+		ce.Reply("**Usage**: $cmdprefix create <public|private> [team ID]")
+		return
+	} else if ce.Portal != nil {
+		ce.Reply("This room is already bridged to a Slack conversation")
+		return
+	}
+	var teamID string
+	if len(ce.Args) == 2 {
+		teamID = ce.Args[1]
+	}
+	userTeam := ce.getUserTeamArg(teamID)
+	if userTeam == nil {
+		return
+	}
+
+	portal, err := ce.User.CreateChannelForRoom(ce.RoomID, userTeam, "", ce.Args[0] == "private")
+	if portal == nil {
+		ce.Reply("Failed to create channel: %v", err)
+	} else if err != nil {
+		ce.Reply("Created #%s, but %v", portal.PlainName, err)
+	} else {
+		ce.Reply("Created #%s and bridged this room to it", portal.PlainName)
+	}
+}
+
+var cmdBridge = &commands.FullHandler{
+	Func: wrapCommand(fnBridge),
+	Name: "bridge",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionPortalManagement,
+		Description: "Bridge the current Matrix room to an existing Slack channel. Pass `--backfill` to also bridge the channel history.",
+		Args:        "<_channel ID_|#_name_> [_team ID_] [--backfill]",
+	},
+	RequiresLogin: true,
+}
+
+func fnBridge(ce *WrappedCommandEvent) {
+	var args []string
+	backfill := false
+	for _, arg := range ce.Args {
+		if arg == "--backfill" {
+			backfill = true
+		} else {
+			args = append(args, arg)
+		}
+	}
+	if len(args) < 1 || len(args) > 2 {
+		ce.Reply("**Usage**: $cmdprefix bridge <channel ID|#name> [team ID] [--backfill]")
+		return
+	} else if ce.Portal != nil {
+		ce.Reply("This room is already bridged to a Slack conversation")
+		return
+	}
+	var teamID string
+	if len(args) == 2 {
+		teamID = args[1]
+	}
+	userTeam := ce.getUserTeamArg(teamID)
+	if userTeam == nil {
+		return
+	}
+
+	portal, err := ce.User.BridgeRoom(ce.RoomID, userTeam, args[0], backfill)
+	if portal == nil {
+		ce.Reply("Failed to bridge room: %v", err)
+	} else if err != nil {
+		ce.Reply("Bridged this room to #%s, but %v", portal.PlainName, err)
+	} else if backfill {
+		ce.Reply("Bridged this room to #%s and queued backfill", portal.PlainName)
+	} else {
+		ce.Reply("Bridged this room to #%s", portal.PlainName)
+	}
+}
+
+var cmdUnbridge = &commands.FullHandler{
+	Func: wrapCommand(fnUnbridge),
+	Name: "unbridge",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionPortalManagement,
+		Description: "Detach the current room from its Slack conversation without deleting the room. The conversation won't get a new room until it's bridged again.",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+func fnUnbridge(ce *WrappedCommandEvent) {
+	if ce.Portal.Type != database.ChannelTypeChannel {
+		ce.Reply("Only channels can be unbridged")
+		return
+	}
+	err := ce.Portal.Unbridge(ce.User)
+	if err != nil {
+		ce.Reply("Failed to unbridge room: %v", err)
+	} else {
+		ce.Reply("This room is no longer bridged to Slack")
+	}
+}
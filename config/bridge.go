@@ -26,6 +26,8 @@ import (
 	"github.com/slack-go/slack"
 
 	"maunium.net/go/mautrix/bridge/bridgeconfig"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/mautrix-slack/database"
 )
@@ -72,6 +74,8 @@ type BridgeConfig struct {
 
 	ManagementRoomText bridgeconfig.ManagementRoomTexts `yaml:"management_room_text"`
 
+	Events EventsConfig `yaml:"events"`
+
 	PortalMessageBuffer int `yaml:"portal_message_buffer"`
 
 	SyncWithCustomPuppets bool `yaml:"sync_with_custom_puppets"`
@@ -101,6 +105,8 @@ type BridgeConfig struct {
 
 	Permissions bridgeconfig.PermissionConfig `yaml:"permissions"`
 
+	Relay RelaybotConfig `yaml:"relay"`
+
 	Backfill struct {
 		Enable bool `yaml:"enable"`
 
@@ -110,6 +116,8 @@ type BridgeConfig struct {
 
 		ImmediateMessages int `yaml:"immediate_messages"`
 
+		WorkersPerTeam int `yaml:"workers_per_team"`
+
 		Incremental IncrementalConfig `yaml:"incremental"`
 	} `yaml:"backfill"`
 
@@ -119,6 +127,37 @@ type BridgeConfig struct {
 	channelNameTemplate    *template.Template `yaml:"-"`
 }
 
+const (
+	EventsModeRTM        = "rtm"
+	EventsModeSocketMode = "socket_mode"
+	EventsModeEventsAPI  = "events_api"
+)
+
+type EventsConfig struct {
+	Mode          string `yaml:"mode"`
+	AppToken      string `yaml:"app_token"`
+	SigningSecret string `yaml:"signing_secret"`
+}
+
+func (ec *EventsConfig) validate() error {
+	switch ec.Mode {
+	case "":
+		ec.Mode = EventsModeRTM
+	case EventsModeRTM:
+	case EventsModeSocketMode:
+		if ec.AppToken == "" {
+			return fmt.Errorf("bridge.events.app_token is required to use Socket Mode")
+		}
+	case EventsModeEventsAPI:
+		if ec.SigningSecret == "" {
+			return fmt.Errorf("bridge.events.signing_secret is required to use the Events API")
+		}
+	default:
+		return fmt.Errorf("unknown event mode %q", ec.Mode)
+	}
+	return nil
+}
+
 type umBridgeConfig BridgeConfig
 
 func (bc *BridgeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -149,7 +188,7 @@ func (bc *BridgeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
-	return nil
+	return bc.Events.validate()
 }
 
 var _ bridgeconfig.BridgeConfig = (*BridgeConfig)(nil)
@@ -191,6 +230,66 @@ func (bc BridgeConfig) FormatBotDisplayname(bot *slack.Bot) string {
 	return buffer.String()
 }
 
+type RelaybotConfig struct {
+	Enabled          bool                         `yaml:"enabled"`
+	AdminOnly        bool                         `yaml:"admin_only"`
+	MessageFormats   map[event.MessageType]string `yaml:"message_formats"`
+	messageTemplates *template.Template           `yaml:"-"`
+}
+
+type umRelaybotConfig RelaybotConfig
+
+func (rc *RelaybotConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	err := unmarshal((*umRelaybotConfig)(rc))
+	if err != nil {
+		return err
+	}
+
+	rc.messageTemplates = template.New("messageTemplates")
+	for key, format := range rc.MessageFormats {
+		_, err = rc.messageTemplates.New(string(key)).Parse(format)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type Sender struct {
+	UserID string
+	event.MemberEventContent
+}
+
+type formatData struct {
+	Sender  Sender
+	Message string
+	Content *event.MessageEventContent
+}
+
+// FormatMessage renders the relay message template for the message type of the given content.
+// The output is HTML, which should be converted to Slack markup before sending.
+func (rc *RelaybotConfig) FormatMessage(content *event.MessageEventContent, sender id.UserID, member event.MemberEventContent) (string, error) {
+	if len(member.Displayname) == 0 {
+		member.Displayname = sender.String()
+	}
+	member.Displayname = template.HTMLEscapeString(member.Displayname)
+	message := content.FormattedBody
+	if content.Format != event.FormatHTML {
+		message = strings.ReplaceAll(template.HTMLEscapeString(content.Body), "\n", "<br/>")
+	}
+	var output strings.Builder
+	err := rc.messageTemplates.ExecuteTemplate(&output, string(content.MsgType), formatData{
+		Sender: Sender{
+			UserID:             template.HTMLEscapeString(sender.String()),
+			MemberEventContent: member,
+		},
+		Content: content,
+		Message: message,
+	})
+	return output.String(), err
+}
+
 type ChannelNameParams struct {
 	Name     string
 	Type     database.ChannelType
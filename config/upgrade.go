@@ -57,6 +57,9 @@ func DoUpgrade(helper *up.Helper) {
 	helper.Copy(up.Str, "bridge", "management_room_text", "welcome_connected")
 	helper.Copy(up.Str, "bridge", "management_room_text", "welcome_unconnected")
 	helper.Copy(up.Str|up.Null, "bridge", "management_room_text", "additional_help")
+	helper.Copy(up.Str, "bridge", "events", "mode")
+	helper.Copy(up.Str, "bridge", "events", "app_token")
+	helper.Copy(up.Str, "bridge", "events", "signing_secret")
 	helper.Copy(up.Bool, "bridge", "encryption", "allow")
 	helper.Copy(up.Bool, "bridge", "encryption", "default")
 	helper.Copy(up.Bool, "bridge", "encryption", "require")
@@ -81,6 +84,7 @@ func DoUpgrade(helper *up.Helper) {
 	helper.Copy(up.Int, "bridge", "backfill", "conversations_count")
 	helper.Copy(up.Int, "bridge", "backfill", "unread_hours_threshold")
 	helper.Copy(up.Int, "bridge", "backfill", "immediate_messages")
+	helper.Copy(up.Int, "bridge", "backfill", "workers_per_team")
 	helper.Copy(up.Map, "bridge", "backfill", "incremental")
 
 	helper.Copy(up.Str, "bridge", "provisioning", "prefix")
@@ -92,9 +96,9 @@ func DoUpgrade(helper *up.Helper) {
 	}
 
 	helper.Copy(up.Map, "bridge", "permissions")
-	//helper.Copy(up.Bool, "bridge", "relay", "enabled")
-	//helper.Copy(up.Bool, "bridge", "relay", "admin_only")
-	//helper.Copy(up.Map, "bridge", "relay", "message_formats")
+	helper.Copy(up.Bool, "bridge", "relay", "enabled")
+	helper.Copy(up.Bool, "bridge", "relay", "admin_only")
+	helper.Copy(up.Map, "bridge", "relay", "message_formats")
 }
 
 var SpacedBlocks = [][]string{
@@ -107,9 +111,10 @@ var SpacedBlocks = [][]string{
 	{"bridge"},
 	{"bridge", "command_prefix"},
 	{"bridge", "management_room_text"},
+	{"bridge", "events"},
 	{"bridge", "encryption"},
 	{"bridge", "provisioning"},
 	{"bridge", "permissions"},
-	//{"bridge", "relay"},
+	{"bridge", "relay"},
 	{"logging"},
 }
@@ -0,0 +1,191 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2022 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"maunium.net/go/mautrix/bridge/status"
+
+	"go.mau.fi/mautrix-slack/database"
+)
+
+const (
+	reconnectBaseDelay = 2 * time.Second
+	reconnectMaxDelay  = 5 * time.Minute
+	// reconnectNoticeAttempts is the number of failed connection attempts in a row after which the
+	// user is told about the problem in their management room.
+	reconnectNoticeAttempts = 5
+	// stableConnectionTime is how long a connection has to stay up before earlier failures are forgotten,
+	// so that connections which drop right after connecting still back off.
+	stableConnectionTime = 1 * time.Minute
+)
+
+var (
+	errInvalidAuth        = errors.New("invalid authentication")
+	errEventSourceStopped = errors.New("Slack event source stopped")
+)
+
+// teamConnection is the supervisor of the Slack connection of a single user team.
+type teamConnection struct {
+	stop   chan struct{}
+	source SlackEventSource
+}
+
+func (conn *teamConnection) stopped() bool {
+	select {
+	case <-conn.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSlackAuthError checks if a Slack error means that the credentials of the user team are no longer valid.
+func isSlackAuthError(err error) bool {
+	if errors.Is(err, errInvalidAuth) {
+		return true
+	}
+	switch err.Error() {
+	case "invalid_auth", "not_authed", "account_inactive", "token_revoked", "user_removed_from_team":
+		return true
+	default:
+		return false
+	}
+}
+
+// reconnectDelay returns how long to wait after the given number of failed connection attempts in a row.
+// The delay grows exponentially and has up to 50% of jitter so that all users don't reconnect at once.
+func reconnectDelay(failures int) time.Duration {
+	delay := reconnectMaxDelay
+	if failures < 10 {
+		delay = reconnectBaseDelay << (failures - 1)
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sendManagementNotice sends a notice to the user's management room, if they have one.
+func (user *User) sendManagementNotice(format string, args ...interface{}) {
+	if user.ManagementRoom == "" {
+		return
+	}
+	_, err := user.bridge.Bot.SendNotice(user.ManagementRoom, fmt.Sprintf(format, args...))
+	if err != nil {
+		user.log.Warnfln("Failed to send notice to management room %s: %v", user.ManagementRoom, err)
+	}
+}
+
+// startTeamConnection connects the user team to Slack and keeps it connected until stopTeamConnection is called.
+func (user *User) startTeamConnection(userTeam *database.UserTeam) {
+	user.teamConnectionsLock.Lock()
+	defer user.teamConnectionsLock.Unlock()
+	if existing, ok := user.teamConnections[userTeam.Key.TeamID]; ok {
+		close(existing.stop)
+	}
+	conn := &teamConnection{stop: make(chan struct{})}
+	user.teamConnections[userTeam.Key.TeamID] = conn
+	go user.superviseTeamConnection(userTeam, conn)
+}
+
+// stopTeamConnection stops reconnecting the user team and disconnects its current event source.
+func (user *User) stopTeamConnection(teamID string) error {
+	user.teamConnectionsLock.Lock()
+	defer user.teamConnectionsLock.Unlock()
+	conn, ok := user.teamConnections[teamID]
+	if !ok {
+		return nil
+	}
+	close(conn.stop)
+	delete(user.teamConnections, teamID)
+	if conn.source != nil {
+		return conn.source.Disconnect()
+	}
+	return nil
+}
+
+// setTeamConnectionSource stores the current event source of the connection, or disconnects it right away if the
+// connection was stopped while the source was being created.
+func (user *User) setTeamConnectionSource(conn *teamConnection, source SlackEventSource) bool {
+	user.teamConnectionsLock.Lock()
+	defer user.teamConnectionsLock.Unlock()
+	if conn.stopped() {
+		_ = source.Disconnect()
+		return false
+	}
+	conn.source = source
+	return true
+}
+
+func (user *User) superviseTeamConnection(userTeam *database.UserTeam, conn *teamConnection) {
+	failures := 0
+	// noticeSent is true if the user was told about the failures and hasn't been told about reconnecting yet
+	noticeSent := false
+	for {
+		source, err := user.connectTeam(userTeam)
+		if err == nil {
+			if !user.setTeamConnectionSource(conn, source) {
+				return
+			}
+			if noticeSent {
+				user.sendManagementNotice("Reconnected to Slack team %s.", userTeam.TeamName)
+				noticeSent = false
+			}
+			connectedAt := time.Now()
+			err = user.slackMessageHandler(userTeam, source)
+			if time.Since(connectedAt) >= stableConnectionTime {
+				failures = 0
+			}
+		}
+		if conn.stopped() {
+			user.log.Debugfln("Stopped connection supervisor for %s", userTeam.Key)
+			return
+		}
+
+		if isSlackAuthError(err) {
+			user.log.Errorfln("Credentials for %s are no longer valid: %v", userTeam.Key, err)
+			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateBadCredentials, Message: err.Error()})
+			user.sendManagementNotice("You were logged out of Slack team %s (%v). Please log in again to continue bridging it.", userTeam.TeamName, err)
+			if !errors.Is(err, errInvalidAuth) {
+				// The login is definitely gone, so there's no point in keeping the team around
+				_ = user.LogoutUserTeam(userTeam)
+			}
+			return
+		}
+
+		failures++
+		delay := reconnectDelay(failures)
+		user.log.Warnfln("Connection to %s failed (attempt %d), reconnecting in %s: %v", userTeam.Key, failures, delay, err)
+		user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateTransientDisconnect, Message: err.Error()})
+		if failures == reconnectNoticeAttempts {
+			noticeSent = true
+			user.sendManagementNotice("Failed to connect to Slack team %s %d times in a row (%v). The bridge will keep retrying.", userTeam.TeamName, failures, err)
+		}
+
+		select {
+		case <-conn.stop:
+			user.log.Debugfln("Stopped connection supervisor for %s", userTeam.Key)
+			return
+		case <-time.After(delay):
+		}
+	}
+}
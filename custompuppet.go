@@ -126,20 +126,20 @@ func (puppet *Puppet) ProcessResponse(resp *mautrix.RespSync, _ string) error {
 	// 	}
 	// }
 
-	// if puppet.EnablePresence {
-	// 	for _, evt := range resp.Presence.Events {
-	// 		if evt.Sender != puppet.CustomMXID {
-	// 			continue
-	// 		}
+	if puppet.EnablePresence {
+		for _, evt := range resp.Presence.Events {
+			if evt.Sender != puppet.CustomMXID {
+				continue
+			}
 
-	// 		err := evt.Content.ParseRaw(evt.Type)
-	// 		if err != nil {
-	// 			continue
-	// 		}
+			err := evt.Content.ParseRaw(evt.Type)
+			if err != nil {
+				continue
+			}
 
-	// 		go puppet.bridge.MatrixHandler.HandlePresence(evt)
-	// 	}
-	// }
+			go puppet.handleMatrixPresence(evt)
+		}
+	}
 
 	return nil
 }
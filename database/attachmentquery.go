@@ -46,6 +46,14 @@ func (aq *AttachmentQuery) GetAllBySlackMessageID(key PortalKey, slackMessageID
 	return aq.getAll(query, key.TeamID, key.ChannelID, slackMessageID)
 }
 
+// GetAllSince returns all attachments sent at or after the given Slack timestamp, including thread replies.
+func (aq *AttachmentQuery) GetAllSince(key PortalKey, slackMessageID string) []*Attachment {
+	query := attachmentSelect + " WHERE team_id=$1 AND channel_id=$2" +
+		" AND slack_message_id>=$3"
+
+	return aq.getAll(query, key.TeamID, key.ChannelID, slackMessageID)
+}
+
 func (aq *AttachmentQuery) getAll(query string, args ...interface{}) []*Attachment {
 	rows, err := aq.db.Query(query, args...)
 	if err != nil {
@@ -99,3 +107,10 @@ func (aq *AttachmentQuery) GetLast(key PortalKey) *Attachment {
 
 	return aq.New().Scan(row)
 }
+
+func (aq *AttachmentQuery) GetLastBefore(key PortalKey, slackMessageID string) *Attachment {
+	query := attachmentSelect + " WHERE team_id=$1 AND channel_id=$2 AND slack_message_id<=$3" +
+		" ORDER BY slack_message_id DESC LIMIT 1"
+
+	return aq.get(query, key.TeamID, key.ChannelID, slackMessageID)
+}
@@ -40,20 +40,36 @@ func (bq *BackfillQuery) NewBackfillState(portalKey *PortalKey) *BackfillState {
 
 const (
 	getBackfillState = `
-		SELECT team_id, channel_id, dispatched, backfill_complete, message_count, immediate_complete
+		SELECT team_id, channel_id, dispatched, backfill_complete, message_count, immediate_complete, priority, max_messages
 		FROM backfill_state
 		WHERE team_id=$1
 			AND channel_id=$2
 	`
 
+	// Manually requested backfills go first, then portals that haven't had their immediate backfill,
+	// then DMs, then the most recently active channels. Channels that have already been backfilled
+	// the least break ties.
 	getNextUnfinishedBackfillState = `
-		SELECT team_id, channel_id, dispatched, backfill_complete, message_count, immediate_complete
+		SELECT bs.team_id, bs.channel_id, bs.dispatched, bs.backfill_complete, bs.message_count, bs.immediate_complete, bs.priority, bs.max_messages
+		FROM backfill_state bs
+		JOIN portal p ON p.team_id=bs.team_id AND p.channel_id=bs.channel_id
+		WHERE bs.team_id=$1
+		AND bs.dispatched IS FALSE
+		AND bs.backfill_complete IS FALSE
+		ORDER BY
+			bs.priority DESC,
+			bs.immediate_complete ASC,
+			CASE WHEN p.type IN ($2, $3) THEN 0 ELSE 1 END ASC,
+			COALESCE((SELECT MAX(m.slack_message_id) FROM message m WHERE m.team_id=bs.team_id AND m.channel_id=bs.channel_id), '') DESC,
+			bs.message_count ASC
+		LIMIT 1
+	`
+
+	getTeamsWithUnfinishedBackfills = `
+		SELECT DISTINCT team_id
 		FROM backfill_state
 		WHERE dispatched IS FALSE
 		AND backfill_complete IS FALSE
-		AND immediate_complete=$1
-		ORDER BY message_count ASC
-		LIMIT 1
 	`
 )
 
@@ -67,10 +83,16 @@ type BackfillState struct {
 	BackfillComplete  bool
 	MessageCount      int
 	ImmediateComplete bool
+	Priority          int
+	// MaxMessages overrides the configured maximum number of messages to backfill if set.
+	MaxMessages int
 }
 
+// BackfillPriorityManual is the priority of backfills requested with the backfill command or provisioning API.
+const BackfillPriorityManual = 10
+
 func (b *BackfillState) Scan(row dbutil.Scannable) *BackfillState {
-	err := row.Scan(&b.Portal.TeamID, &b.Portal.ChannelID, &b.Dispatched, &b.BackfillComplete, &b.MessageCount, &b.ImmediateComplete)
+	err := row.Scan(&b.Portal.TeamID, &b.Portal.ChannelID, &b.Dispatched, &b.BackfillComplete, &b.MessageCount, &b.ImmediateComplete, &b.Priority, &b.MaxMessages)
 	if err != nil {
 		if !errors.Is(err, sql.ErrNoRows) {
 			b.log.Errorln("Database scan failed:", err)
@@ -83,15 +105,17 @@ func (b *BackfillState) Scan(row dbutil.Scannable) *BackfillState {
 func (b *BackfillState) Upsert() {
 	_, err := b.db.Exec(`
 		INSERT INTO backfill_state
-			(team_id, channel_id, dispatched, backfill_complete, message_count, immediate_complete)
-		VALUES ($1, $2, $3, $4, $5, $6)
+			(team_id, channel_id, dispatched, backfill_complete, message_count, immediate_complete, priority, max_messages)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (team_id, channel_id)
 		DO UPDATE SET
 			dispatched=EXCLUDED.dispatched,
 			backfill_complete=EXCLUDED.backfill_complete,
 			message_count=EXCLUDED.message_count,
-			immediate_complete=EXCLUDED.immediate_complete`,
-		b.Portal.TeamID, b.Portal.ChannelID, b.Dispatched, b.BackfillComplete, b.MessageCount, b.ImmediateComplete)
+			immediate_complete=EXCLUDED.immediate_complete,
+			priority=EXCLUDED.priority,
+			max_messages=EXCLUDED.max_messages`,
+		b.Portal.TeamID, b.Portal.ChannelID, b.Dispatched, b.BackfillComplete, b.MessageCount, b.ImmediateComplete, b.Priority, b.MaxMessages)
 	if err != nil {
 		b.log.Warnfln("Failed to insert backfill state for %s: %v", b.Portal, err)
 	}
@@ -124,25 +148,28 @@ func (bq *BackfillQuery) GetBackfillState(portalKey *PortalKey) (backfillState *
 	return
 }
 
-func (bq *BackfillQuery) GetNextUnfinishedBackfillState() (backfillState *BackfillState) {
-	rows, err := bq.db.Query(getNextUnfinishedBackfillState, false)
+func (bq *BackfillQuery) GetNextUnfinishedBackfillState(teamID string) (backfillState *BackfillState) {
+	row := bq.db.QueryRow(getNextUnfinishedBackfillState, teamID, ChannelTypeDM, ChannelTypeGroupDM)
+	if row == nil {
+		return nil
+	}
+	return bq.NewBackfillState(&PortalKey{}).Scan(row)
+}
+
+func (bq *BackfillQuery) GetTeamsWithUnfinishedBackfills() (teamIDs []string) {
+	rows, err := bq.db.Query(getTeamsWithUnfinishedBackfills)
 	if err != nil || rows == nil {
 		bq.log.Error(err)
 		return
 	}
 	defer rows.Close()
-	if rows.Next() {
-		backfillState = bq.NewBackfillState(&PortalKey{}).Scan(rows)
-	} else {
-		rows, err := bq.db.Query(getNextUnfinishedBackfillState, true)
-		if err != nil || rows == nil {
-			bq.log.Error(err)
-			return
-		}
-		defer rows.Close()
-		if rows.Next() {
-			backfillState = bq.NewBackfillState(&PortalKey{}).Scan(rows)
+	for rows.Next() {
+		var teamID string
+		if err = rows.Scan(&teamID); err != nil {
+			bq.log.Errorln("Database scan failed:", err)
+			continue
 		}
+		teamIDs = append(teamIDs, teamID)
 	}
 	return
 }
@@ -36,14 +36,27 @@ type Message struct {
 	MatrixID id.EventID
 
 	SlackThreadID string
+	// EditTimestamp is the Slack timestamp of the last edit that was bridged to Matrix. It's empty for
+	// messages that haven't been edited, and UnknownEditTimestamp for messages stored before edits were tracked.
+	EditTimestamp string
 
 	AuthorID string
 }
 
+// UnknownEditTimestamp is the edit timestamp of messages whose edit state wasn't stored. It's stored as NULL.
+const UnknownEditTimestamp = "unknown"
+
+func (m *Message) editTsPtr() *string {
+	if m.EditTimestamp == UnknownEditTimestamp {
+		return nil
+	}
+	return &m.EditTimestamp
+}
+
 func (m *Message) Scan(row dbutil.Scannable) *Message {
-	var threadID sql.NullString
+	var threadID, editTs sql.NullString
 
-	err := row.Scan(&m.Channel.TeamID, &m.Channel.ChannelID, &m.SlackID, &m.MatrixID, &m.AuthorID, &threadID)
+	err := row.Scan(&m.Channel.TeamID, &m.Channel.ChannelID, &m.SlackID, &m.MatrixID, &m.AuthorID, &threadID, &editTs)
 	if err != nil {
 		if !errors.Is(err, sql.ErrNoRows) {
 			m.log.Errorln("Database scan failed:", err)
@@ -53,6 +66,11 @@ func (m *Message) Scan(row dbutil.Scannable) *Message {
 	}
 
 	m.SlackThreadID = threadID.String
+	if editTs.Valid {
+		m.EditTimestamp = editTs.String
+	} else {
+		m.EditTimestamp = UnknownEditTimestamp
+	}
 
 	return m
 }
@@ -60,10 +78,10 @@ func (m *Message) Scan(row dbutil.Scannable) *Message {
 func (m *Message) Insert(txn dbutil.Transaction) {
 	query := "INSERT INTO message" +
 		" (team_id, channel_id, slack_message_id, matrix_message_id," +
-		" author_id, slack_thread_id) VALUES ($1, $2, $3, $4, $5, $6)"
+		" author_id, slack_thread_id, slack_edit_ts) VALUES ($1, $2, $3, $4, $5, $6, $7)"
 
 	args := []interface{}{m.Channel.TeamID,
-		m.Channel.ChannelID, m.SlackID, m.MatrixID, m.AuthorID, strPtr(m.SlackThreadID)}
+		m.Channel.ChannelID, m.SlackID, m.MatrixID, m.AuthorID, strPtr(m.SlackThreadID), m.editTsPtr()}
 
 	var err error
 	if txn != nil {
@@ -77,6 +95,19 @@ func (m *Message) Insert(txn dbutil.Transaction) {
 	}
 }
 
+func (m *Message) SetEditTimestamp(editTs string) {
+	query := "UPDATE message SET slack_edit_ts=$1" +
+		" WHERE team_id=$2 AND channel_id=$3 AND slack_message_id=$4"
+
+	_, err := m.db.Exec(query, editTs, m.Channel.TeamID, m.Channel.ChannelID, m.SlackID)
+
+	if err != nil {
+		m.log.Warnfln("Failed to update edit timestamp of %s@%s: %v", m.Channel, m.SlackID, err)
+	} else {
+		m.EditTimestamp = editTs
+	}
+}
+
 func (m *Message) Delete() {
 	query := "DELETE FROM message" +
 		" WHERE team_id=$1 AND channel_id=$2 AND slack_message_id=$3 AND matrix_message_id=$4"
@@ -28,7 +28,7 @@ type MessageQuery struct {
 
 const (
 	messageSelect = "SELECT team_id, channel_id, slack_message_id," +
-		" matrix_message_id, author_id, slack_thread_id FROM message"
+		" matrix_message_id, author_id, slack_thread_id, slack_edit_ts FROM message"
 )
 
 func (mq *MessageQuery) New() *Message {
@@ -41,10 +41,28 @@ func (mq *MessageQuery) New() *Message {
 func (mq *MessageQuery) GetAll(key PortalKey) []*Message {
 	query := messageSelect + " WHERE team_id=$1 AND channel_id=$2"
 
-	rows, err := mq.db.Query(query, key.TeamID, key.ChannelID)
+	return mq.getAll(query, key.TeamID, key.ChannelID)
+}
+
+// GetAllSince returns all messages sent at or after the given Slack timestamp, including thread replies.
+func (mq *MessageQuery) GetAllSince(key PortalKey, slackID string) []*Message {
+	query := messageSelect + " WHERE team_id=$1 AND channel_id=$2 AND slack_message_id>=$3"
+
+	return mq.getAll(query, key.TeamID, key.ChannelID, slackID)
+}
+
+func (mq *MessageQuery) GetAllInThread(key PortalKey, slackThreadID string) []*Message {
+	query := messageSelect + " WHERE team_id=$1 AND channel_id=$2 AND slack_thread_id=$3 AND slack_message_id<>$4"
+
+	return mq.getAll(query, key.TeamID, key.ChannelID, slackThreadID, slackThreadID)
+}
+
+func (mq *MessageQuery) getAll(query string, args ...interface{}) []*Message {
+	rows, err := mq.db.Query(query, args...)
 	if err != nil || rows == nil {
 		return nil
 	}
+	defer rows.Close()
 
 	messages := []*Message{}
 	for rows.Next() {
@@ -117,3 +135,14 @@ func (mq *MessageQuery) GetLast(key PortalKey) *Message {
 
 	return mq.New().Scan(row)
 }
+
+func (mq *MessageQuery) GetLastBefore(key PortalKey, slackID string) *Message {
+	query := messageSelect + " WHERE team_id=$1 AND channel_id=$2 AND slack_message_id<=$3 ORDER BY slack_message_id DESC LIMIT 1"
+
+	row := mq.db.QueryRow(query, key.TeamID, key.ChannelID, slackID)
+	if row == nil {
+		return nil
+	}
+
+	return mq.New().Scan(row)
+}
@@ -70,15 +70,20 @@ type Portal struct {
 	FirstEventID id.EventID
 	NextBatchID  id.BatchID
 	FirstSlackID string
+
+	RelayUserID id.UserID
+
+	// Unbridged is set when the portal room was detached from the channel, so that a new room isn't created.
+	Unbridged bool
 }
 
 func (p *Portal) Scan(row dbutil.Scannable) *Portal {
-	var mxid, dmUserID, avatarURL, firstEventID, nextBatchID, firstSlackID sql.NullString
+	var mxid, dmUserID, avatarURL, firstEventID, nextBatchID, firstSlackID, relayUserID sql.NullString
 
 	err := row.Scan(&p.Key.TeamID, &p.Key.ChannelID, &mxid,
 		&p.Type, &dmUserID, &p.PlainName, &p.Name, &p.NameSet, &p.Topic,
 		&p.TopicSet, &p.Avatar, &avatarURL, &p.AvatarSet, &firstEventID,
-		&p.Encrypted, &nextBatchID, &firstSlackID)
+		&p.Encrypted, &nextBatchID, &firstSlackID, &relayUserID, &p.Unbridged)
 
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -94,6 +99,7 @@ func (p *Portal) Scan(row dbutil.Scannable) *Portal {
 	p.FirstEventID = id.EventID(firstEventID.String)
 	p.NextBatchID = id.BatchID(nextBatchID.String)
 	p.FirstSlackID = firstSlackID.String
+	p.RelayUserID = id.UserID(relayUserID.String)
 
 	return p
 }
@@ -106,17 +112,25 @@ func (p *Portal) mxidPtr() *id.RoomID {
 	return nil
 }
 
+func (p *Portal) relayUserPtr() *id.UserID {
+	if p.RelayUserID != "" {
+		return &p.RelayUserID
+	}
+
+	return nil
+}
+
 func (p *Portal) Insert() {
 	query := "INSERT INTO portal" +
 		" (team_id, channel_id, mxid, type, dm_user_id, plain_name," +
 		" name, name_set, topic, topic_set, avatar, avatar_url, avatar_set," +
-		" first_event_id, encrypted, next_batch_id, first_slack_id)" +
-		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)"
+		" first_event_id, encrypted, next_batch_id, first_slack_id, relay_user_id, unbridged)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)"
 
 	_, err := p.db.Exec(query, p.Key.TeamID, p.Key.ChannelID,
 		p.mxidPtr(), p.Type, p.DMUserID, p.PlainName, p.Name, p.NameSet,
 		p.Topic, p.TopicSet, p.Avatar, p.AvatarURL.String(), p.AvatarSet,
-		p.FirstEventID.String(), p.Encrypted, p.NextBatchID.String(), p.FirstSlackID)
+		p.FirstEventID.String(), p.Encrypted, p.NextBatchID.String(), p.FirstSlackID, p.relayUserPtr(), p.Unbridged)
 
 	if err != nil {
 		p.log.Warnfln("Failed to insert %s: %v", p.Key, err)
@@ -127,13 +141,13 @@ func (p *Portal) Update(txn dbutil.Transaction) {
 	query := "UPDATE portal SET" +
 		" mxid=$1, type=$2, dm_user_id=$3, plain_name=$4, name=$5, name_set=$6," +
 		" topic=$7, topic_set=$8, avatar=$9, avatar_url=$10, avatar_set=$11," +
-		" first_event_id=$12, encrypted=$13, next_batch_id=$14, first_slack_id=$15" +
-		" WHERE team_id=$16 AND channel_id=$17"
+		" first_event_id=$12, encrypted=$13, next_batch_id=$14, first_slack_id=$15, relay_user_id=$16," +
+		" unbridged=$17 WHERE team_id=$18 AND channel_id=$19"
 
 	args := []interface{}{p.mxidPtr(), p.Type, p.DMUserID, p.PlainName,
 		p.Name, p.NameSet, p.Topic, p.TopicSet, p.Avatar, p.AvatarURL.String(),
 		p.AvatarSet, p.FirstEventID.String(), p.Encrypted, p.NextBatchID.String(), p.FirstSlackID,
-		p.Key.TeamID, p.Key.ChannelID}
+		p.relayUserPtr(), p.Unbridged, p.Key.TeamID, p.Key.ChannelID}
 
 	var err error
 	if txn != nil {
@@ -155,6 +169,56 @@ func (p *Portal) Delete() {
 	}
 }
 
+// portalUnbridgeQueries clear everything that belonged to the portal's old room. The portal row
+// itself is kept, so the rows referencing it aren't deleted by the foreign key cascade.
+var portalUnbridgeQueries = []string{
+	"DELETE FROM message WHERE team_id=$1 AND channel_id=$2",
+	"DELETE FROM reaction WHERE team_id=$1 AND channel_id=$2",
+	"DELETE FROM attachment WHERE team_id=$1 AND channel_id=$2",
+	"DELETE FROM backfill_state WHERE team_id=$1 AND channel_id=$2",
+	"DELETE FROM user_team_portal WHERE slack_team_id=$1 AND portal_channel_id=$2",
+}
+
+// Unbridge detaches the portal from its room and marks it as unbridged in a single transaction.
+func (p *Portal) Unbridge() error {
+	txn, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	query := "UPDATE portal SET" +
+		" mxid=NULL, name_set=false, topic_set=false, avatar_set=false, first_event_id='', encrypted=false," +
+		" next_batch_id='', first_slack_id='', relay_user_id=NULL, unbridged=true" +
+		" WHERE team_id=$1 AND channel_id=$2"
+	_, err = txn.Exec(query, p.Key.TeamID, p.Key.ChannelID)
+	if err != nil {
+		return err
+	}
+	for _, query = range portalUnbridgeQueries {
+		_, err = txn.Exec(query, p.Key.TeamID, p.Key.ChannelID)
+		if err != nil {
+			return err
+		}
+	}
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	p.MXID = ""
+	p.NameSet = false
+	p.TopicSet = false
+	p.AvatarSet = false
+	p.FirstEventID = ""
+	p.Encrypted = false
+	p.NextBatchID = ""
+	p.FirstSlackID = ""
+	p.RelayUserID = ""
+	p.Unbridged = true
+	return nil
+}
+
 func (p *Portal) InsertUser(utk UserTeamKey) {
 	query := "INSERT INTO user_team_portal" +
 		" (matrix_user_id, slack_user_id, slack_team_id, portal_channel_id)" +
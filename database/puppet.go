@@ -28,7 +28,8 @@ import (
 const (
 	puppetSelect = "SELECT team_id, user_id, name, name_set, avatar," +
 		" avatar_url, avatar_set, enable_presence, custom_mxid, access_token," +
-		" next_batch, is_bot, enable_receipts, contact_info_set" +
+		" next_batch, is_bot, enable_receipts, contact_info_set," +
+		" status_text, status_emoji, status_expiration" +
 		" FROM puppet "
 )
 
@@ -58,6 +59,11 @@ type Puppet struct {
 	EnableReceipts bool
 
 	ContactInfoSet bool
+
+	StatusText  string
+	StatusEmoji string
+	// StatusExpiration is the unix timestamp when the status is cleared, or 0 if it doesn't expire.
+	StatusExpiration int64
 }
 
 func (p *Puppet) Scan(row dbutil.Scannable) *Puppet {
@@ -67,7 +73,8 @@ func (p *Puppet) Scan(row dbutil.Scannable) *Puppet {
 
 	err := row.Scan(&teamID, &userID, &p.Name, &p.NameSet, &avatar, &avatarURL,
 		&p.AvatarSet, &enablePresence, &customMXID, &accessToken, &nextBatch,
-		&p.IsBot, &p.EnableReceipts, &p.ContactInfoSet)
+		&p.IsBot, &p.EnableReceipts, &p.ContactInfoSet,
+		&p.StatusText, &p.StatusEmoji, &p.StatusExpiration)
 
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -93,12 +100,14 @@ func (p *Puppet) Insert() {
 	query := "INSERT INTO puppet" +
 		" (team_id, user_id, name, name_set, avatar, avatar_url, avatar_set," +
 		" enable_presence, custom_mxid, access_token, next_batch," +
-		" is_bot, enable_receipts, contact_info_set)" +
-		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)"
+		" is_bot, enable_receipts, contact_info_set," +
+		" status_text, status_emoji, status_expiration)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)"
 
 	_, err := p.db.Exec(query, p.TeamID, p.UserID, p.Name, p.NameSet, p.Avatar,
 		p.AvatarURL.String(), p.AvatarSet, p.EnablePresence, p.CustomMXID,
-		p.AccessToken, p.NextBatch, p.IsBot, p.EnableReceipts, p.ContactInfoSet)
+		p.AccessToken, p.NextBatch, p.IsBot, p.EnableReceipts, p.ContactInfoSet,
+		p.StatusText, p.StatusEmoji, p.StatusExpiration)
 
 	if err != nil {
 		p.log.Warnfln("Failed to insert %s-%s: %v", p.TeamID, p.UserID, err)
@@ -109,12 +118,14 @@ func (p *Puppet) Update() {
 	query := "UPDATE puppet" +
 		" SET name=$1, name_set=$2, avatar=$3, avatar_url=$4, avatar_set=$5," +
 		"     enable_presence=$6, custom_mxid=$7, access_token=$8," +
-		"     next_batch=$9, is_bot=$10, enable_receipts=$11, contact_info_set=$12" +
-		" WHERE team_id=$13 AND user_id=$14"
+		"     next_batch=$9, is_bot=$10, enable_receipts=$11, contact_info_set=$12," +
+		"     status_text=$13, status_emoji=$14, status_expiration=$15" +
+		" WHERE team_id=$16 AND user_id=$17"
 
 	_, err := p.db.Exec(query, p.Name, p.NameSet, p.Avatar,
 		p.AvatarURL.String(), p.AvatarSet, p.EnablePresence, p.CustomMXID,
-		p.AccessToken, p.NextBatch, p.IsBot, p.EnableReceipts, p.ContactInfoSet, p.TeamID, p.UserID)
+		p.AccessToken, p.NextBatch, p.IsBot, p.EnableReceipts, p.ContactInfoSet,
+		p.StatusText, p.StatusEmoji, p.StatusExpiration, p.TeamID, p.UserID)
 
 	if err != nil {
 		p.log.Warnfln("Failed to update %s-%s: %v", p.TeamID, p.UserID, err)
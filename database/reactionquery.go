@@ -45,8 +45,14 @@ func (rq *ReactionQuery) GetAllByMatrixID(key PortalKey, matrixEventID id.EventI
 	return rq.getAll(query, key.TeamID, key.ChannelID, matrixEventID)
 }
 
+func (rq *ReactionQuery) GetAllBySlackMessageID(key PortalKey, slackMessageID string) []*Reaction {
+	query := reactionSelect + " WHERE team_id=$1 AND channel_id=$2 AND slack_message_id=$3"
+
+	return rq.getAll(query, key.TeamID, key.ChannelID, slackMessageID)
+}
+
 func (rq *ReactionQuery) getAll(query string, args ...interface{}) []*Reaction {
-	rows, err := rq.db.Query(query)
+	rows, err := rq.db.Query(query, args...)
 	if err != nil || rows == nil {
 		return nil
 	}
@@ -1,4 +1,4 @@
--- v1 -> v15: Latest revision
+-- v1 -> v20: Latest revision
 
 CREATE TABLE portal (
 	team_id    TEXT,
@@ -23,6 +23,9 @@ CREATE TABLE portal (
 	next_batch_id  TEXT,
 	first_slack_id TEXT,
 
+	relay_user_id TEXT,
+	unbridged     BOOLEAN NOT NULL DEFAULT false,
+
 	PRIMARY KEY (team_id, channel_id)
 );
 
@@ -48,6 +51,10 @@ CREATE TABLE puppet (
 
 	contact_info_set BOOLEAN NOT NULL DEFAULT false,
 
+	status_text       TEXT NOT NULL DEFAULT '',
+	status_emoji      TEXT NOT NULL DEFAULT '',
+	status_expiration BIGINT NOT NULL DEFAULT 0,
+
 	PRIMARY KEY(team_id, user_id)
 );
 
@@ -87,6 +94,7 @@ CREATE TABLE message (
 
 	slack_message_id TEXT NOT NULL,
     slack_thread_id TEXT,
+	slack_edit_ts   TEXT,
 	matrix_message_id  TEXT NOT NULL UNIQUE,
 
 	author_id TEXT   NOT NULL,
@@ -142,6 +150,8 @@ CREATE TABLE backfill_state (
     dispatched         BOOLEAN,
     message_count      INTEGER,
     immediate_complete BOOLEAN,
+    priority           INTEGER NOT NULL DEFAULT 0,
+    max_messages       INTEGER NOT NULL DEFAULT 0,
     PRIMARY KEY (team_id, channel_id),
     FOREIGN KEY (team_id, channel_id) REFERENCES portal (team_id, channel_id) ON DELETE CASCADE
 );
@@ -0,0 +1,3 @@
+-- v16: Add relay user for portals
+
+ALTER TABLE portal ADD COLUMN relay_user_id TEXT;
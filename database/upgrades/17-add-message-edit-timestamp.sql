@@ -0,0 +1,3 @@
+-- v17: Store the timestamp of the last bridged edit of messages
+
+ALTER TABLE message ADD COLUMN slack_edit_ts TEXT;
@@ -0,0 +1,4 @@
+-- v18: Allow manually requested backfills with a priority and message limit
+
+ALTER TABLE backfill_state ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE backfill_state ADD COLUMN max_messages INTEGER NOT NULL DEFAULT 0;
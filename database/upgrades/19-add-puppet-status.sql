@@ -0,0 +1,5 @@
+-- v19: Store the custom status of Slack users
+
+ALTER TABLE puppet ADD COLUMN status_text TEXT NOT NULL DEFAULT '';
+ALTER TABLE puppet ADD COLUMN status_emoji TEXT NOT NULL DEFAULT '';
+ALTER TABLE puppet ADD COLUMN status_expiration BIGINT NOT NULL DEFAULT 0;
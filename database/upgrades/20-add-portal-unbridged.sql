@@ -0,0 +1,3 @@
+-- v20: Remember channels that were unbridged so that their rooms aren't recreated
+
+ALTER TABLE portal ADD COLUMN unbridged BOOLEAN NOT NULL DEFAULT false;
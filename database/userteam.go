@@ -19,6 +19,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"sync/atomic"
 
 	log "maunium.net/go/maulogger/v2"
 
@@ -89,6 +90,30 @@ func (utq *UserTeamQuery) GetAllBySlackTeamID(teamID string) []*UserTeam {
 	return tokens
 }
 
+func (utq *UserTeamQuery) GetAllForPortal(portal PortalKey) []*UserTeam {
+	query := userTeamSelect + `
+		JOIN user_team_portal utp ON utp.matrix_user_id = ut.mxid
+			AND utp.slack_team_id = ut.team_id
+			AND utp.slack_user_id = ut.slack_id
+		WHERE utp.slack_team_id = $1
+			AND utp.portal_channel_id = $2
+			AND ut.token IS NOT NULL`
+
+	rows, err := utq.db.Query(query, portal.TeamID, portal.ChannelID)
+	if err != nil || rows == nil {
+		return nil
+	}
+
+	defer rows.Close()
+
+	tokens := []*UserTeam{}
+	for rows.Next() {
+		tokens = append(tokens, utq.New().Scan(rows))
+	}
+
+	return tokens
+}
+
 func (utq *UserTeamQuery) GetFirstUserTeamForPortal(portal *PortalKey) *UserTeam {
 	query := userTeamSelect + `
 		JOIN user_team_portal utp ON utp.matrix_user_id = ut.mxid
@@ -129,10 +154,22 @@ type UserTeam struct {
 	Token       string
 	CookieToken string
 
-	Client *slack.Client
+	// client is swapped when the team reconnects while other goroutines are using it, so it's only
+	// accessed atomically through Client and SetClient.
+	client atomic.Pointer[slack.Client]
 	RTM    *slack.RTM
 }
 
+// Client returns the current Slack client of the user team, or nil if it's not connected.
+func (ut *UserTeam) Client() *slack.Client {
+	return ut.client.Load()
+}
+
+// SetClient replaces the Slack client of the user team.
+func (ut *UserTeam) SetClient(client *slack.Client) {
+	ut.client.Store(client)
+}
+
 func (ut *UserTeam) GetMXID() id.UserID {
 	return ut.Key.MXID
 }
@@ -150,7 +187,7 @@ func (ut *UserTeam) IsLoggedIn() bool {
 }
 
 func (ut *UserTeam) IsConnected() bool {
-	return ut.Client != nil && ut.RTM != nil
+	return ut.Client() != nil
 }
 
 func (ut *UserTeam) Scan(row dbutil.Scannable) *UserTeam {
@@ -3,10 +3,16 @@ package main
 import (
 	_ "embed"
 	"encoding/json"
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
 
 	"go.mau.fi/mautrix-slack/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -16,6 +22,12 @@ var emojis map[string]string
 
 var re regexp.Regexp = *regexp.MustCompile(`:[^:\s]*:`)
 
+// customEmojiRegex matches shortcodes that can be custom emoji names. Purely numeric
+// names are excluded so that times like 10:30:00 don't trigger emoji lookups.
+var customEmojiRegex = regexp.MustCompile(`:([a-z0-9_+'-]*[a-z_+'-][a-z0-9_+'-]*):`)
+var shortcodeReactionRegex = regexp.MustCompile(`^:([^:\s]+):$`)
+var customEmojiImgRegex = regexp.MustCompile(`<img data-mx-emoticon [^>]*alt="([^"]*)"[^>]*>`)
+
 func replaceShortcodesWithEmojis(text string) string {
 	return re.ReplaceAllStringFunc(text, shortcodeToEmoji)
 }
@@ -67,7 +79,7 @@ func init() {
 
 func (br *SlackBridge) ImportEmojis(userTeam *database.UserTeam, list *map[string]string, overwrite bool) error {
 	if list == nil {
-		resp, err := userTeam.Client.GetEmoji()
+		resp, err := userTeam.Client().GetEmoji()
 		if err != nil {
 			br.ZLog.Err(err).Msg("failed to fetch emoji list from Slack")
 			return err
@@ -110,6 +122,17 @@ func (br *SlackBridge) ImportEmojis(userTeam *database.UserTeam, list *map[strin
 			dbEmoji.Alias = alias
 			dbEmoji.ImageURL = uri
 			dbEmoji.Upsert(nil)
+		} else if target := br.DB.Emoji.GetBySlackID(alias, userTeam.Key.TeamID); target != nil {
+			// Aliases added by emoji_changed events point at emojis that were imported earlier
+			dbEmoji := br.DB.Emoji.New()
+			dbEmoji.SlackID = key
+			dbEmoji.SlackTeam = userTeam.Key.TeamID
+			dbEmoji.Alias = target.Alias
+			if !target.ImageURL.IsEmpty() {
+				dbEmoji.Alias = alias
+				dbEmoji.ImageURL = target.ImageURL
+			}
+			dbEmoji.Upsert(nil)
 		} else if unicode := shortcodeToEmoji(alias); unicode != alias {
 			dbEmoji := br.DB.Emoji.New()
 			dbEmoji.SlackID = key
@@ -122,6 +145,73 @@ func (br *SlackBridge) ImportEmojis(userTeam *database.UserTeam, list *map[strin
 	return nil
 }
 
+func customEmojiHTML(mxc, name string) string {
+	return fmt.Sprintf(`<img data-mx-emoticon src="%[1]s" alt=":%[2]s:" title=":%[2]s:" height="32"/>`, mxc, name)
+}
+
+// htmlToContentWithEmoji works like format.HTMLToContent, but keeps the shortcodes
+// of inline custom emoji in the plaintext body instead of dropping the images.
+func htmlToContentWithEmoji(htmlText string) event.MessageEventContent {
+	content := format.HTMLToContent(htmlText)
+	if content.Format == event.FormatHTML && strings.Contains(htmlText, "data-mx-emoticon") {
+		content.Body = format.HTMLToMarkdown(customEmojiImgRegex.ReplaceAllString(htmlText, "$1"))
+	}
+	return content
+}
+
+// ReplaceEmojiShortcodes replaces emoji shortcodes in plain text like topics and names.
+// Custom emoji that are images are left as shortcodes, as there's no way to render them.
+func (br *SlackBridge) ReplaceEmojiShortcodes(text string, userTeam *database.UserTeam) string {
+	text = replaceShortcodesWithEmojis(text)
+	if userTeam == nil {
+		return text
+	}
+	return customEmojiRegex.ReplaceAllStringFunc(text, func(code string) string {
+		name := strings.Trim(code, ":")
+		emoji := br.GetEmoji(name, userTeam)
+		if emoji == name || strings.HasPrefix(emoji, "mxc://") {
+			return code
+		}
+		return emoji
+	})
+}
+
+// emojiRefreshInterval is the minimum time between fetching the emoji list of a team because of an unknown emoji.
+// New emojis are also added by emoji_changed events, so this is only a fallback.
+const emojiRefreshInterval = 10 * time.Minute
+
+// shouldRefreshEmojis checks whether the emoji list of the team can be fetched again, and marks it as fetched if so.
+func (br *SlackBridge) shouldRefreshEmojis(teamID string) bool {
+	br.emojiRefreshesLock.Lock()
+	defer br.emojiRefreshesLock.Unlock()
+	if time.Since(br.emojiRefreshes[teamID]) < emojiRefreshInterval {
+		return false
+	}
+	br.emojiRefreshes[teamID] = time.Now()
+	return true
+}
+
+// handleEmojiChanged keeps the custom emojis of the team up to date when they're added, removed or renamed on Slack.
+func (br *SlackBridge) handleEmojiChanged(userTeam *database.UserTeam, evt *slack.EmojiChangedEvent) {
+	switch evt.SubType {
+	case "add":
+		go br.ImportEmojis(userTeam, &map[string]string{evt.Name: evt.Value}, false)
+	case "remove":
+		for _, name := range evt.Names {
+			dbEmoji := br.DB.Emoji.GetBySlackID(name, userTeam.Key.TeamID)
+			if dbEmoji != nil {
+				dbEmoji.Delete()
+			}
+		}
+	default:
+		// Renames don't include the new value, so just fetch the whole list again
+		go br.ImportEmojis(userTeam, nil, false)
+	}
+}
+
+// GetEmoji converts a Slack emoji shortcode into a unicode emoji or an mxc:// URI of a custom emoji.
+// If the emoji isn't known, the emoji list of the team is refreshed in the background and the shortcode
+// is returned as-is.
 func (br *SlackBridge) GetEmoji(shortcode string, userTeam *database.UserTeam) string {
 	converted := convertSlackReaction(shortcode)
 	if converted != shortcode {
@@ -129,9 +219,8 @@ func (br *SlackBridge) GetEmoji(shortcode string, userTeam *database.UserTeam) s
 	}
 
 	dbEmoji := br.DB.Emoji.GetBySlackID(shortcode, userTeam.Key.TeamID)
-	if dbEmoji == nil {
-		br.ImportEmojis(userTeam, nil, false)
-		dbEmoji = br.DB.Emoji.GetBySlackID(shortcode, userTeam.Key.TeamID)
+	if dbEmoji == nil && br.shouldRefreshEmojis(userTeam.Key.TeamID) {
+		go br.ImportEmojis(userTeam, nil, false)
 	}
 
 	if dbEmoji != nil && !dbEmoji.ImageURL.IsEmpty() {
@@ -0,0 +1,460 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2022 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+	log "maunium.net/go/maulogger/v2"
+
+	"go.mau.fi/mautrix-slack/config"
+	"go.mau.fi/mautrix-slack/database"
+)
+
+// SlackEventSource delivers the Slack events of a single user team. Events use the same types as
+// RTM events regardless of how they were received, so they can all go through handleSlackEvent.
+type SlackEventSource interface {
+	// Events returns the channel that incoming events are sent to.
+	Events() <-chan slack.RTMEvent
+	// Done returns a channel that is closed when the source has stopped for good.
+	Done() <-chan struct{}
+	// Disconnect stops the source.
+	Disconnect() error
+}
+
+// rtmEventSource receives events through the legacy RTM websocket of the user team.
+type rtmEventSource struct {
+	rtm  *slack.RTM
+	done chan struct{}
+}
+
+func newRTMEventSource(rtm *slack.RTM) *rtmEventSource {
+	source := &rtmEventSource{
+		rtm:  rtm,
+		done: make(chan struct{}),
+	}
+	go func() {
+		rtm.ManageConnection()
+		close(source.done)
+	}()
+	return source
+}
+
+func (source *rtmEventSource) Events() <-chan slack.RTMEvent {
+	return source.rtm.IncomingEvents
+}
+
+func (source *rtmEventSource) Done() <-chan struct{} {
+	return source.done
+}
+
+func (source *rtmEventSource) Disconnect() error {
+	err := source.rtm.Disconnect()
+	if errors.Is(err, slack.ErrAlreadyDisconnected) {
+		return nil
+	}
+	return err
+}
+
+// appEventSource receives the events of a user team that are dispatched from the events of the
+// bridge's Slack app, which arrive either through Socket Mode or the Events API.
+type appEventSource struct {
+	dispatcher *appEventDispatcher
+	key        database.UserTeamKey
+	events     chan slack.RTMEvent
+	done       chan struct{}
+	doneOnce   sync.Once
+
+	// queue holds dispatched events in order until the user's event loop takes them, so that a slow
+	// user doesn't block the dispatcher.
+	queue       []slack.RTMEvent
+	queueLock   sync.Mutex
+	queueSignal chan struct{}
+}
+
+// maxQueuedAppEvents is the number of events that can be waiting for a user team before new ones are dropped.
+const maxQueuedAppEvents = 1000
+
+func (source *appEventSource) Events() <-chan slack.RTMEvent {
+	return source.events
+}
+
+func (source *appEventSource) Done() <-chan struct{} {
+	return source.done
+}
+
+func (source *appEventSource) Disconnect() error {
+	source.dispatcher.unsubscribe(source)
+	source.stop()
+	return nil
+}
+
+// enqueue adds an event to the end of the queue without blocking. It returns false if the queue is full.
+func (source *appEventSource) enqueue(evt slack.RTMEvent) bool {
+	source.queueLock.Lock()
+	if len(source.queue) >= maxQueuedAppEvents {
+		source.queueLock.Unlock()
+		return false
+	}
+	source.queue = append(source.queue, evt)
+	source.queueLock.Unlock()
+	select {
+	case source.queueSignal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// forwardQueue moves queued events to the events channel in order until the source is stopped.
+func (source *appEventSource) forwardQueue() {
+	for {
+		source.queueLock.Lock()
+		if len(source.queue) == 0 {
+			source.queueLock.Unlock()
+			select {
+			case <-source.queueSignal:
+				continue
+			case <-source.done:
+				return
+			}
+		}
+		evt := source.queue[0]
+		source.queue[0] = slack.RTMEvent{}
+		source.queue = source.queue[1:]
+		source.queueLock.Unlock()
+		select {
+		case source.events <- evt:
+		case <-source.done:
+			return
+		}
+	}
+}
+
+func (source *appEventSource) stop() {
+	source.doneOnce.Do(func() {
+		close(source.done)
+	})
+}
+
+// slackEventCallback is the payload of Events API requests and Socket Mode events_api messages.
+type slackEventCallback struct {
+	Type         string          `json:"type"`
+	Challenge    string          `json:"challenge"`
+	TeamID       string          `json:"team_id"`
+	Event        json.RawMessage `json:"event"`
+	EventContext string          `json:"event_context"`
+
+	Authorizations []struct {
+		TeamID string `json:"team_id"`
+		UserID string `json:"user_id"`
+	} `json:"authorizations"`
+}
+
+// appEventDispatcher routes events of the bridge's Slack app to the user teams they belong to.
+type appEventDispatcher struct {
+	bridge *SlackBridge
+	log    log.Logger
+	// appAPI is used to list all the users an event is visible to. It's nil if there's no app-level token.
+	appAPI *slack.Client
+
+	lock    sync.RWMutex
+	sources map[database.UserTeamKey]*appEventSource
+}
+
+func newAppEventDispatcher(br *SlackBridge) *appEventDispatcher {
+	dispatcher := &appEventDispatcher{
+		bridge:  br,
+		log:     br.Log.Sub("SlackEvents"),
+		sources: make(map[database.UserTeamKey]*appEventSource),
+	}
+	if appToken := br.Config.Bridge.Events.AppToken; appToken != "" {
+		dispatcher.appAPI = slack.New("", slack.OptionAppLevelToken(appToken), slack.OptionLog(SlackgoLogger{dispatcher.log.Sub("SlackGo")}))
+	}
+	return dispatcher
+}
+
+// subscribe creates an event source for the user team. The given connected event is delivered
+// first, as there's no per-user connection that would tell the handler it's connected.
+func (dispatcher *appEventDispatcher) subscribe(userTeam *database.UserTeam, connected *slack.ConnectedEvent) *appEventSource {
+	source := &appEventSource{
+		dispatcher: dispatcher,
+		key:        userTeam.Key,
+		events:     make(chan slack.RTMEvent, 50),
+		done:       make(chan struct{}),
+
+		queueSignal: make(chan struct{}, 1),
+	}
+	source.events <- slack.RTMEvent{Type: "connected", Data: connected}
+	go source.forwardQueue()
+
+	dispatcher.lock.Lock()
+	if existing, ok := dispatcher.sources[source.key]; ok {
+		existing.stop()
+	}
+	dispatcher.sources[source.key] = source
+	dispatcher.lock.Unlock()
+	return source
+}
+
+func (dispatcher *appEventDispatcher) unsubscribe(source *appEventSource) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	if dispatcher.sources[source.key] == source {
+		delete(dispatcher.sources, source.key)
+	}
+}
+
+// appEventChannelID finds the ID of the channel that an Events API inner event happened in, if any.
+func appEventChannelID(rawEvent json.RawMessage) string {
+	var evt struct {
+		Channel   json.RawMessage `json:"channel"`
+		ChannelID string          `json:"channel_id"`
+		Item      struct {
+			Channel string `json:"channel"`
+		} `json:"item"`
+	}
+	if json.Unmarshal(rawEvent, &evt) != nil {
+		return ""
+	}
+	var channelID string
+	if json.Unmarshal(evt.Channel, &channelID) == nil && channelID != "" {
+		return channelID
+	}
+	var channel struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(evt.Channel, &channel) == nil && channel.ID != "" {
+		return channel.ID
+	} else if evt.ChannelID != "" {
+		return evt.ChannelID
+	}
+	return evt.Item.Channel
+}
+
+// findTargets finds the user teams that an event should be delivered to. Slack only lists one of the
+// users that can see an event in its authorizations, so events in channels also go to every user in
+// the channel's portal. If none of those users are logged in here, the full list of users is fetched
+// from Slack if there's an app-level token. Events outside channels go to everyone in the team as a
+// last resort, like they would with RTM.
+func (dispatcher *appEventDispatcher) findTargets(callback *slackEventCallback) []*appEventSource {
+	channelID := appEventChannelID(callback.Event)
+	var channelMembers []*database.UserTeam
+	if channelID != "" {
+		channelMembers = dispatcher.bridge.DB.UserTeam.GetAllForPortal(database.NewPortalKey(callback.TeamID, channelID))
+	}
+	authorized := make(map[string]struct{}, len(callback.Authorizations))
+	for _, auth := range callback.Authorizations {
+		authorized[auth.UserID] = struct{}{}
+	}
+	for _, member := range channelMembers {
+		authorized[member.Key.SlackID] = struct{}{}
+	}
+
+	targets, teamSources := dispatcher.matchSources(callback.TeamID, authorized)
+	if len(targets) == 0 && len(teamSources) > 0 && dispatcher.appAPI != nil && callback.EventContext != "" {
+		auths, err := dispatcher.appAPI.ListEventAuthorizations(callback.EventContext)
+		if err != nil {
+			dispatcher.log.Warnfln("Failed to list authorizations of event in team %s: %v", callback.TeamID, err)
+		}
+		for _, auth := range auths {
+			authorized[auth.UserID] = struct{}{}
+		}
+		targets, teamSources = dispatcher.matchSources(callback.TeamID, authorized)
+	}
+	if len(targets) == 0 && channelID == "" {
+		targets = teamSources
+	}
+	return targets
+}
+
+// matchSources returns the sources of the given Slack users in the team, along with all sources in the team.
+func (dispatcher *appEventDispatcher) matchSources(teamID string, userIDs map[string]struct{}) (targets, teamSources []*appEventSource) {
+	dispatcher.lock.RLock()
+	defer dispatcher.lock.RUnlock()
+	for key, source := range dispatcher.sources {
+		if key.TeamID != teamID {
+			continue
+		}
+		teamSources = append(teamSources, source)
+		if _, ok := userIDs[key.SlackID]; ok {
+			targets = append(targets, source)
+		}
+	}
+	return
+}
+
+// parseAppEvent converts an Events API inner event into the matching RTM event type.
+func parseAppEvent(rawEvent json.RawMessage) (slack.RTMEvent, error) {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(rawEvent, &typed)
+	if err != nil {
+		return slack.RTMEvent{}, err
+	}
+	mapped, ok := slack.EventMapping[typed.Type]
+	if !ok {
+		return slack.RTMEvent{}, slack.NewUnmappedError("events api", typed.Type, rawEvent)
+	}
+	data := reflect.New(reflect.TypeOf(mapped)).Interface()
+	err = json.Unmarshal(rawEvent, data)
+	if err != nil {
+		return slack.RTMEvent{}, err
+	}
+	return slack.RTMEvent{Type: typed.Type, Data: data}, nil
+}
+
+func (dispatcher *appEventDispatcher) dispatch(callback *slackEventCallback) {
+	if callback.Type != "event_callback" {
+		dispatcher.log.Debugfln("Ignoring %s event from Slack", callback.Type)
+		return
+	}
+	evt, err := parseAppEvent(callback.Event)
+	if err != nil {
+		dispatcher.log.Debugfln("Ignoring event in team %s: %v", callback.TeamID, err)
+		return
+	}
+	targets := dispatcher.findTargets(callback)
+	if len(targets) == 0 {
+		dispatcher.log.Debugfln("Dropping %s event in team %s: no connected users", evt.Type, callback.TeamID)
+		return
+	}
+	for _, target := range targets {
+		if !target.enqueue(evt) {
+			dispatcher.log.Warnfln("Dropping %s event for %s: too many events queued", evt.Type, target.key)
+		}
+	}
+}
+
+// runSocketMode receives the app's events through Socket Mode until the app-level token is rejected.
+func (dispatcher *appEventDispatcher) runSocketMode(appToken string) {
+	failures := 0
+	for {
+		api := slack.New("", slack.OptionAppLevelToken(appToken), slack.OptionLog(SlackgoLogger{dispatcher.log.Sub("SlackGo")}))
+		client := socketmode.New(api)
+		stopped := make(chan struct{})
+		go dispatcher.handleSocketModeEvents(client, stopped)
+		err := client.Run()
+		close(stopped)
+
+		if err != nil && isSlackAuthError(err) {
+			dispatcher.log.Errorfln("Slack rejected the app-level token, not receiving any events: %v", err)
+			return
+		}
+		failures++
+		delay := reconnectDelay(failures)
+		dispatcher.log.Warnfln("Socket Mode connection failed (attempt %d), reconnecting in %s: %v", failures, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+func (dispatcher *appEventDispatcher) handleSocketModeEvents(client *socketmode.Client, stopped <-chan struct{}) {
+	for {
+		select {
+		case evt := <-client.Events:
+			switch evt.Type {
+			case socketmode.EventTypeConnected:
+				dispatcher.log.Infoln("Connected to Slack Socket Mode")
+			case socketmode.EventTypeEventsAPI:
+				client.Ack(*evt.Request)
+				var callback slackEventCallback
+				err := json.Unmarshal(evt.Request.Payload, &callback)
+				if err != nil {
+					dispatcher.log.Warnln("Failed to parse Socket Mode event:", err)
+					continue
+				}
+				dispatcher.dispatch(&callback)
+			case socketmode.EventTypeInteractive, socketmode.EventTypeSlashCommand:
+				client.Ack(*evt.Request)
+			}
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// HandleEventsAPIRequest receives the app's events through the Events API.
+func (dispatcher *appEventDispatcher) HandleEventsAPIRequest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	verifier, err := slack.NewSecretsVerifier(r.Header, dispatcher.bridge.Config.Bridge.Events.SigningSecret)
+	if err == nil {
+		_, _ = verifier.Write(body)
+		err = verifier.Ensure()
+	}
+	if err != nil {
+		dispatcher.log.Warnln("Rejecting Events API request with invalid signature:", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var callback slackEventCallback
+	err = json.Unmarshal(body, &callback)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if callback.Type == "url_verification" {
+		jsonResponse(w, http.StatusOK, map[string]string{"challenge": callback.Challenge})
+		return
+	}
+	// Dispatching only queues the event, so this responds within the 3 seconds Slack expects and keeps the order
+	dispatcher.dispatch(&callback)
+	w.WriteHeader(http.StatusOK)
+}
+
+// startAppEvents starts receiving events of the bridge's Slack app if the bridge isn't using RTM.
+func (br *SlackBridge) startAppEvents() {
+	switch br.Config.Bridge.Events.Mode {
+	case config.EventsModeSocketMode:
+		br.appEvents = newAppEventDispatcher(br)
+		go br.appEvents.runSocketMode(br.Config.Bridge.Events.AppToken)
+	case config.EventsModeEventsAPI:
+		br.appEvents = newAppEventDispatcher(br)
+		br.AS.Router.HandleFunc("/_slack/events", br.appEvents.HandleEventsAPIRequest).Methods(http.MethodPost)
+	}
+}
+
+// newEventSource starts receiving the events of a user team whose client has just been created.
+func (user *User) newEventSource(userTeam *database.UserTeam) (SlackEventSource, error) {
+	if user.bridge.appEvents == nil {
+		userTeam.RTM = userTeam.Client().NewRTM()
+		return newRTMEventSource(userTeam.RTM), nil
+	}
+	userTeam.RTM = nil
+	auth, err := userTeam.Client().AuthTest()
+	if err != nil {
+		return nil, err
+	}
+	return user.bridge.appEvents.subscribe(userTeam, &slack.ConnectedEvent{
+		Info: &slack.Info{
+			URL:  auth.URL,
+			User: &slack.UserDetails{ID: auth.UserID, Name: auth.User},
+			Team: &slack.Team{ID: auth.TeamID, Name: auth.Team},
+		},
+	}), nil
+}
@@ -39,19 +39,8 @@ var escapeFixer = regexp.MustCompile(`\\(__[^_]|\*\*[^*])`)
 
 const mentionedUsersContextKey = "fi.mau.slack.mentioned_users"
 
-func (portal *Portal) renderSlackMarkdown(text string) *event.MessageEventContent {
-	text = replaceShortcodesWithEmojis(text)
-
-	text = escapeFixer.ReplaceAllStringFunc(text, func(s string) string {
-		return s[:2] + `\` + s[2:]
-	})
-
-	mdRenderer := goldmark.New(
-		format.Extensions, format.HTMLOptions,
-		goldmark.WithExtensions(&SlackTag{portal}),
-	)
-
-	content := format.RenderMarkdownCustom(text, mdRenderer)
+func (portal *Portal) renderSlackMarkdown(text string, userTeam *database.UserTeam) *event.MessageEventContent {
+	content := htmlToContentWithEmoji(portal.mrkdwnToMatrixHtml(text, userTeam))
 	return &content
 }
 
@@ -181,6 +170,23 @@ func (n *astSlackURL) String() string {
 	}
 }
 
+type astSlackEmoji struct {
+	ast.BaseInline
+
+	name string
+}
+
+var _ ast.Node = (*astSlackEmoji)(nil)
+var astKindSlackEmoji = ast.NewNodeKind("SlackEmoji")
+
+func (n *astSlackEmoji) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+func (n *astSlackEmoji) Kind() ast.NodeKind {
+	return astKindSlackEmoji
+}
+
 type slackTagParser struct{}
 
 // Regex matching Slack docs at https://api.slack.com/reference/surfaces/formatting#retrieving-messages
@@ -222,12 +228,59 @@ func (s *slackTagParser) CloseBlock(parent ast.Node, pc parser.Context) {
 	// nothing to do
 }
 
+// slackEmojiParser parses the shortcodes left after replaceShortcodesWithEmojis,
+// which may be the workspace's custom emoji.
+type slackEmojiParser struct{}
+
+var slackEmojiRegex = regexp.MustCompile("^" + customEmojiRegex.String())
+var defaultSlackEmojiParser = &slackEmojiParser{}
+
+func (s *slackEmojiParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (s *slackEmojiParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	match := slackEmojiRegex.FindSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	block.Advance(len(match[0]))
+	return &astSlackEmoji{name: string(match[1])}
+}
+
+func (s *slackEmojiParser) CloseBlock(parent ast.Node, pc parser.Context) {
+	// nothing to do
+}
+
 type slackTagHTMLRenderer struct {
-	portal *Portal
+	portal   *Portal
+	userTeam *database.UserTeam
 }
 
 func (r *slackTagHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(astKindSlackTag, r.renderSlackTag)
+	reg.Register(astKindSlackEmoji, r.renderSlackEmoji)
+}
+
+func (r *slackTagHTMLRenderer) renderSlackEmoji(w goldmarkUtil.BufWriter, source []byte, n ast.Node, entering bool) (status ast.WalkStatus, err error) {
+	status = ast.WalkContinue
+	if !entering {
+		return
+	}
+	node := n.(*astSlackEmoji)
+	var emoji string
+	if r.userTeam != nil {
+		emoji = r.portal.bridge.GetEmoji(node.name, r.userTeam)
+	}
+	if strings.HasPrefix(emoji, "mxc://") {
+		_, _ = w.WriteString(customEmojiHTML(emoji, node.name))
+	} else if emoji != "" && emoji != node.name {
+		_, _ = w.WriteString(emoji)
+	} else {
+		_, _ = fmt.Fprintf(w, ":%s:", node.name)
+	}
+	return
 }
 
 func (r *slackTagHTMLRenderer) renderSlackTag(w goldmarkUtil.BufWriter, source []byte, n ast.Node, entering bool) (status ast.WalkStatus, err error) {
@@ -281,14 +334,16 @@ func (r *slackTagHTMLRenderer) renderSlackTag(w goldmarkUtil.BufWriter, source [
 }
 
 type SlackTag struct {
-	Portal *Portal
+	Portal   *Portal
+	UserTeam *database.UserTeam
 }
 
 func (e *SlackTag) Extend(m goldmark.Markdown) {
 	m.Parser().AddOptions(parser.WithInlineParsers(
 		goldmarkUtil.Prioritized(defaultSlackTagParser, 150),
+		goldmarkUtil.Prioritized(defaultSlackEmojiParser, 150),
 	))
 	m.Renderer().AddOptions(renderer.WithNodeRenderers(
-		goldmarkUtil.Prioritized(&slackTagHTMLRenderer{e.Portal}, 150),
+		goldmarkUtil.Prioritized(&slackTagHTMLRenderer{e.Portal, e.UserTeam}, 150),
 	))
 }
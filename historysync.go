@@ -19,7 +19,9 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"html"
 	"strings"
 	"time"
 
@@ -54,9 +56,19 @@ func (bridge *SlackBridge) backfillInChunks(backfillState *database.BackfillStat
 	defer portal.backfillLock.Unlock()
 
 	backfillState.SetDispatched(true)
-	defer backfillState.SetDispatched(false)
+	defer func() {
+		if backfillState.BackfillComplete {
+			// Manually requested backfills only apply until they're done
+			backfillState.Priority = 0
+			backfillState.MaxMessages = 0
+		}
+		backfillState.SetDispatched(false)
+	}()
 
 	maxMessages := bridge.Config.Bridge.Backfill.Incremental.MaxMessages.GetMaxMessagesFor(portal.Type)
+	if backfillState.MaxMessages > 0 {
+		maxMessages = backfillState.MaxMessages
+	}
 
 	if maxMessages > 0 && backfillState.MessageCount >= maxMessages {
 		backfillState.BackfillComplete = true
@@ -65,119 +77,271 @@ func (bridge *SlackBridge) backfillInChunks(backfillState *database.BackfillStat
 		return
 	}
 
-	slackReqParams := slack.GetConversationHistoryParameters{
-		ChannelID: portal.Key.ChannelID,
-		Inclusive: false,
-	}
-	var isLatestEvents bool
 	portal.latestEventBackfillLock.Lock()
-
-	slackReqParams.Latest = portal.FirstSlackID
-	if portal.FirstSlackID == "" {
-		// Portal is empty -> events are latest
-		isLatestEvents = true
-	}
-
+	// If the portal is empty, the first page contains the latest events and might be sent at the end of
+	// the room as non-historical events, so make sure we don't process new messages until it's done.
+	isLatestEvents := portal.FirstSlackID == ""
 	if !isLatestEvents {
 		// We'll use normal batch sending, so no need to keep blocking new message processing
 		portal.latestEventBackfillLock.Unlock()
-	} else {
-		// This might involve sending events at the end of the room as non-historical events,
-		// make sure we don't process messages until this is done.
-		defer portal.latestEventBackfillLock.Unlock()
 	}
+	unlockLatestEvents := func() {
+		if isLatestEvents {
+			isLatestEvents = false
+			portal.latestEventBackfillLock.Unlock()
+		}
+	}
+	defer unlockLatestEvents()
 
-	userTeam := bridge.DB.UserTeam.GetFirstUserTeamForPortal(&portal.Key)
+	userTeam := portal.getBackfillUserTeam()
 	if userTeam == nil {
 		bridge.Log.Errorfln("Couldn't find logged in user with access to %s for backfilling!", portal.Key)
 		backfillState.BackfillComplete = true
 		backfillState.Upsert()
 		return
 	}
-	if userTeam.CookieToken != "" {
-		userTeam.Client = slack.New(userTeam.Token, slack.OptionCookie("d", userTeam.CookieToken))
-	} else {
-		userTeam.Client = slack.New(userTeam.Token)
-	}
-
-	// Fetch actual messages from Slack.
-	resp, err := userTeam.Client.GetConversationHistory(&slackReqParams)
-	if err != nil {
-		bridge.Log.Errorfln("Error fetching Slack messages for backfilling %s: %v", portal.Key, err)
-		backfillState.BackfillComplete = true
-		backfillState.Upsert()
-		return
-	}
-	allMsgs := resp.Messages
-
-	if len(allMsgs) == 0 {
-		bridge.Log.Debugfln("Not backfilling %s: no bridgeable messages found", portal.Key)
-		backfillState.BackfillComplete = true
-		backfillState.Upsert()
-		return
-	}
 
 	// Update the backfill status here after the room has been created.
 	portal.updateBackfillStatus(backfillState)
 
-	var maxBatchEvents int
-	if !backfillState.ImmediateComplete {
-		maxBatchEvents = -1
-	} else {
-		maxBatchEvents = bridge.Config.Bridge.Backfill.Incremental.MessagesPerBatch
-	}
+	latest := portal.FirstSlackID
+	var cursor string
+	var attempts int
+	for {
+		limit := bridge.Config.Bridge.Backfill.Incremental.MessagesPerBatch
+		if maxMessages > 0 && maxMessages-backfillState.MessageCount < limit {
+			limit = maxMessages - backfillState.MessageCount
+		}
 
-	bridge.Log.Infofln("Backfilling %d messages in %s, %d messages at a time", len(allMsgs), portal.Key, maxBatchEvents)
-	toBackfill := allMsgs[0:]
-	for len(toBackfill) > 0 {
-		var msgs []slack.Message
-		if len(toBackfill) <= maxBatchEvents || maxBatchEvents < 0 {
-			msgs = toBackfill
-			toBackfill = nil
-		} else {
-			msgs = toBackfill[:maxBatchEvents]
-			toBackfill = toBackfill[maxBatchEvents:]
-		}
-
-		if len(msgs) > 0 {
-			time.Sleep(time.Duration(bridge.Config.Bridge.Backfill.Incremental.PostBatchDelay) * time.Second)
-			bridge.Log.Debugfln("Backfilling %d messages in %s", len(msgs), portal.Key)
-			resp, err := portal.backfill(userTeam, msgs, !backfillState.ImmediateComplete)
-			if resp != nil && (resp.BaseInsertionEventID != "" || !isLatestEvents) {
-				backfillState.MessageCount += len(msgs)
-			} else if err != nil {
-				// the backfill function has already logged an error; just store state in DB and stop filling
-				if len(allMsgs) != 0 {
-					portal.FirstSlackID = allMsgs[len(msgs)-1].Timestamp
-					portal.Update(nil)
-				} else {
-					backfillState.BackfillComplete = true
-				}
+		// Fetch actual messages from Slack.
+		var resp *slack.GetConversationHistoryResponse
+		err := bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+			resp, err = userTeam.Client().GetConversationHistory(&slack.GetConversationHistoryParameters{
+				ChannelID: portal.Key.ChannelID,
+				Latest:    latest,
+				Cursor:    cursor,
+				Inclusive: false,
+				Limit:     limit,
+			})
+			return
+		})
+		if err != nil {
+			if isPermanentBackfillError(err) {
+				bridge.Log.Errorfln("Error fetching Slack messages for backfilling %s, giving up: %v", portal.Key, err)
+				backfillState.BackfillComplete = true
+				break
+			}
+			attempts++
+			if attempts > maxBackfillRetries {
+				bridge.Log.Errorfln("Error fetching Slack messages for backfilling %s, will retry later: %v", portal.Key, err)
 				backfillState.Upsert()
 				return
 			}
+			delay := reconnectDelay(attempts)
+			bridge.Log.Warnfln("Error fetching Slack messages for backfilling %s, retrying in %s: %v", portal.Key, delay, err)
+			time.Sleep(delay)
+			continue
+		} else if len(resp.Messages) == 0 {
+			bridge.Log.Debugfln("Not backfilling %s: no bridgeable messages found", portal.Key)
+			backfillState.BackfillComplete = true
+			break
 		}
-	}
-	bridge.Log.Debugfln("Finished backfilling %d messages in %s", len(allMsgs), portal.Key)
 
-	backfillState.MessageCount += len(allMsgs)
+		time.Sleep(time.Duration(bridge.Config.Bridge.Backfill.Incremental.PostBatchDelay) * time.Second)
+		bridge.Log.Debugfln("Backfilling %d messages in %s", len(resp.Messages), portal.Key)
+		_, err = portal.backfill(userTeam, resp.Messages, !backfillState.ImmediateComplete)
+		unlockLatestEvents()
+		if err != nil {
+			// Keep the cursor as-is so that the same page is tried again instead of being lost
+			attempts++
+			if attempts > maxBackfillRetries {
+				bridge.Log.Errorfln("Error backfilling %d messages in %s, will retry later: %v", len(resp.Messages), portal.Key, err)
+				backfillState.Upsert()
+				return
+			}
+			delay := reconnectDelay(attempts)
+			bridge.Log.Warnfln("Error backfilling %d messages in %s, retrying in %s: %v", len(resp.Messages), portal.Key, delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		attempts = 0
 
-	if !backfillState.ImmediateComplete {
+		backfillState.MessageCount += len(resp.Messages)
 		backfillState.ImmediateComplete = true
+		backfillState.Upsert()
+		if backfillState.Priority > 0 {
+			// Someone asked for this backfill, so keep them posted on the progress
+			portal.updateBackfillStatus(backfillState)
+		}
+
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			// Slack said there's no more history to backfill.
+			backfillState.BackfillComplete = true
+			break
+		} else if maxMessages > 0 && backfillState.MessageCount >= maxMessages {
+			bridge.Log.Infofln("Reached backfill limit of %d messages in %s", maxMessages, portal.Key)
+			backfillState.BackfillComplete = true
+			break
+		}
+		cursor = resp.ResponseMetaData.NextCursor
 	}
+	bridge.Log.Debugfln("Finished backfilling %s, %d messages in total", portal.Key, backfillState.MessageCount)
 
-	if !resp.HasMore {
-		// Slack said there's no more history to backfill.
-		backfillState.BackfillComplete = true
-		portal.updateBackfillStatus(backfillState)
+	portal.updateBackfillStatus(backfillState)
+	backfillState.Upsert()
+
+	portal.syncBackfillReadState(userTeam)
+	// Pins of older messages couldn't be synced when the room was created, as the messages weren't bridged yet
+	portal.SyncSlackPins(userTeam)
+}
+
+var (
+	errBackfillDisabled     = errors.New("backfilling is disabled on this bridge")
+	errBackfillInProgress   = errors.New("a backfill is already in progress in this room")
+	errBackfillLimitReached = errors.New("this room has already been backfilled up to the configured limit")
+	errBackfillNotLoggedIn  = errors.New("you're not logged into the Slack team of this room")
+	errBackfillNotInRoom    = errors.New("you're not in this room")
+)
+
+// checkBackfillAccess checks that the user can request backfilling the portal, which requires being
+// logged into the Slack team of the portal and being joined to the room.
+func (portal *Portal) checkBackfillAccess(user *User) error {
+	userTeam := user.GetUserTeam(portal.Key.TeamID)
+	if userTeam == nil || !userTeam.IsLoggedIn() {
+		return errBackfillNotLoggedIn
+	}
+	member := portal.MainIntent().Member(portal.MXID, user.MXID)
+	if member == nil || member.Membership != event.MembershipJoin {
+		return errBackfillNotInRoom
+	}
+	return nil
+}
+
+// RequestBackfill queues a high priority backfill of up to count more messages in the portal, or up
+// to the configured limit if count is zero. Completed backfills are resumed, which allows retrying
+// backfills that were stopped by an error.
+func (portal *Portal) RequestBackfill(count int) error {
+	if !portal.bridge.Config.Bridge.Backfill.Enable {
+		return errBackfillDisabled
 	}
 
+	// Hold the queue lock so that a worker can't claim the backfill while it's being updated
+	portal.bridge.BackfillQueue.lock.Lock()
+	backfillState := portal.bridge.DB.Backfill.GetBackfillState(&portal.Key)
+	if backfillState == nil {
+		backfillState = portal.bridge.DB.Backfill.NewBackfillState(&portal.Key)
+		backfillState.ImmediateComplete = portal.FirstSlackID != ""
+	} else if backfillState.Dispatched {
+		portal.bridge.BackfillQueue.lock.Unlock()
+		return errBackfillInProgress
+	}
+	maxMessages := portal.bridge.Config.Bridge.Backfill.Incremental.MaxMessages.GetMaxMessagesFor(portal.Type)
+	if count <= 0 && maxMessages > 0 && backfillState.MessageCount >= maxMessages {
+		// The backfill would stop immediately without fetching anything
+		portal.bridge.BackfillQueue.lock.Unlock()
+		return errBackfillLimitReached
+	}
+	backfillState.BackfillComplete = false
+	backfillState.Priority = database.BackfillPriorityManual
+	if count > 0 {
+		backfillState.MaxMessages = backfillState.MessageCount + count
+	} else {
+		backfillState.MaxMessages = 0
+	}
 	backfillState.Upsert()
+	portal.bridge.BackfillQueue.lock.Unlock()
 
-	// TODO: add these config options
-	// if bridge.Config.Bridge.HistorySync.UnreadHoursThreshold > 0 && conv.LastMessageTimestamp.Before(time.Now().Add(time.Duration(-bridge.Config.Bridge.HistorySync.UnreadHoursThreshold)*time.Hour)) {
-	// 	user.markSelfReadFull(portal)
-	// }
+	portal.log.Infofln("Queued manual backfill (message limit: %d)", backfillState.MaxMessages)
+	portal.updateBackfillStatus(backfillState)
+	portal.bridge.BackfillQueue.ReCheck()
+	return nil
+}
+
+// getLastBridged finds the newest bridged Slack message, optionally limited to messages sent at or
+// before the given Slack timestamp, and returns its timestamp and the Matrix event to use for it.
+func (portal *Portal) getLastBridged(before string) (string, id.EventID) {
+	var lastMessage *database.Message
+	var lastAttachment *database.Attachment
+	if before == "" {
+		lastMessage = portal.bridge.DB.Message.GetLast(portal.Key)
+		lastAttachment = portal.bridge.DB.Attachment.GetLast(portal.Key)
+	} else {
+		lastMessage = portal.bridge.DB.Message.GetLastBefore(portal.Key, before)
+		lastAttachment = portal.bridge.DB.Attachment.GetLastBefore(portal.Key, before)
+	}
+	if lastMessage == nil && lastAttachment == nil {
+		return "", ""
+	} else if lastMessage == nil || (lastAttachment != nil && parseSlackTimestamp(lastAttachment.SlackMessageID).After(parseSlackTimestamp(lastMessage.SlackID))) {
+		return lastAttachment.SlackMessageID, lastAttachment.MatrixEventID
+	} else {
+		return lastMessage.SlackID, lastMessage.MatrixID
+	}
+}
+
+func (portal *Portal) isOlderThanUnreadThreshold(slackTimestamp string) bool {
+	threshold := portal.bridge.Config.Bridge.Backfill.UnreadHoursThreshold
+	// 0 marks every backfilled chat as read, negative values disable the threshold
+	return threshold >= 0 && time.Since(parseSlackTimestamp(slackTimestamp)) >= time.Duration(threshold)*time.Hour
+}
+
+// syncBackfillReadState marks the portal as read for the user's double puppet after backfilling.
+// Portals whose newest message is older than the unread hours threshold are marked fully read,
+// others are marked read up to the read marker the user has on Slack.
+func (portal *Portal) syncBackfillReadState(userTeam *database.UserTeam) {
+	puppet := portal.bridge.GetPuppetByCustomMXID(userTeam.Key.MXID)
+	if puppet == nil || puppet.CustomIntent() == nil {
+		return
+	}
+	lastID, lastEventID := portal.getLastBridged("")
+	if lastID == "" {
+		return
+	}
+
+	readEventID := lastEventID
+	if !portal.isOlderThanUnreadThreshold(lastID) {
+		var info *slack.Channel
+		err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+			info, err = userTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{
+				ChannelID: portal.Key.ChannelID,
+			})
+			return
+		})
+		if err != nil {
+			portal.log.Warnfln("Failed to get read marker of %s after backfill: %v", userTeam.Key.SlackID, err)
+			return
+		} else if info.LastRead == "" || parseSlackTimestamp(info.LastRead).Unix() == 0 {
+			return
+		}
+		_, readEventID = portal.getLastBridged(info.LastRead)
+		if readEventID == "" {
+			return
+		}
+	}
+
+	err := puppet.CustomIntent().MarkRead(portal.MXID, readEventID)
+	if err != nil {
+		portal.log.Warnfln("Failed to mark %s as read by %s after backfill: %v", readEventID, puppet.CustomMXID, err)
+	}
+}
+
+// getBackfillUserTeam finds a logged in user with access to the portal. The connected user team is
+// preferred, so that backfilling shares its Slack client instead of creating a new one every time.
+func (portal *Portal) getBackfillUserTeam() *database.UserTeam {
+	userTeam := portal.bridge.DB.UserTeam.GetFirstUserTeamForPortal(&portal.Key)
+	if userTeam == nil {
+		return nil
+	}
+	if user := portal.bridge.GetUserByMXID(userTeam.Key.MXID); user != nil {
+		if connected := user.GetUserTeam(userTeam.Key.TeamID); connected != nil && connected.Client() != nil {
+			return connected
+		}
+	}
+	if userTeam.CookieToken != "" {
+		userTeam.SetClient(slack.New(userTeam.Token, slack.OptionCookie("d", userTeam.CookieToken)))
+	} else {
+		userTeam.SetClient(slack.New(userTeam.Token))
+	}
+	return userTeam
 }
 
 func (portal *Portal) deterministicEventID(sender string, messageID string, partName string) id.EventID {
@@ -217,12 +381,13 @@ func (portal *Portal) makeBackfillEvent(intent *appservice.IntentAPI, msg *event
 	content := event.Content{
 		Parsed: msg,
 	}
-	if portal.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry {
-		if info.SlackThreadTs != info.SlackTimestamp {
-			threadInfo, found := (*threadInfos)[info.SlackThreadTs]
-			if found {
-				content.Parsed.(*event.MessageEventContent).RelatesTo = &event.RelatesTo{}
-				content.Parsed.(*event.MessageEventContent).RelatesTo.SetThread(threadInfo.ThreadOrigin, threadInfo.ThreadLatest)
+	if info.SlackThreadTs != "" && info.SlackThreadTs != info.SlackTimestamp {
+		threadInfo, found := (*threadInfos)[info.SlackThreadTs]
+		if found {
+			content.Parsed.(*event.MessageEventContent).RelatesTo = &event.RelatesTo{}
+			content.Parsed.(*event.MessageEventContent).RelatesTo.SetThread(threadInfo.ThreadOrigin, threadInfo.ThreadLatest)
+			// Event IDs are only known in advance on hungryserv, elsewhere the fallback points at the thread root
+			if portal.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry {
 				threadInfo.ThreadLatest = portal.deterministicEventID(info.SlackAuthor, info.SlackTimestamp, partName)
 				(*threadInfos)[info.SlackThreadTs] = threadInfo
 			}
@@ -248,18 +413,114 @@ func (portal *Portal) makeBackfillEvent(intent *appservice.IntentAPI, msg *event
 	return &e
 }
 
-func (portal *Portal) backfill(userTeam *database.UserTeam, messages []slack.Message, isForward bool) (*mautrix.RespBatchSend, error) {
-	req := mautrix.ReqBatchSend{
-		Events:             []*event.Event{},
-		StateEventsAtStart: []*event.Event{},
+func isBackfillableMessage(message *slack.Message) bool {
+	return message.Type == "message" && (message.SubType == "" || message.SubType == "me_message" || message.SubType == "bot_message")
+}
+
+// maxBackfillRetries is how many times a failed backfill page is retried before giving up until the next attempt.
+const maxBackfillRetries = 5
+
+// isPermanentBackfillError returns true if retrying the history request that returned the given error won't help.
+func isPermanentBackfillError(err error) bool {
+	if isSlackAuthError(err) {
+		return true
 	}
-	if !isForward {
-		if portal.FirstEventID == "" {
-			return nil, fmt.Errorf("no first event ID saved while backfilling backwards, can't backfill")
+	var statusErr slack.StatusCodeError
+	if errors.As(err, &statusErr) {
+		return !statusErr.Retryable()
+	}
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) {
+		switch slackErr.Err {
+		case "internal_error", "fatal_error", "service_unavailable", "request_timeout", "ratelimited":
+			return false
+		default:
+			return true
 		}
-		req.PrevEventID = portal.FirstEventID
 	}
-	addedMembers := make(map[id.UserID]*Puppet)
+	return false
+}
+
+const threadRepliesPageSize = 200
+
+// fetchThreadReplies fetches all replies in the given thread, excluding the thread root itself. The replies
+// aren't filtered, and if fetching fails partway through, the replies fetched so far are returned with the error.
+func (portal *Portal) fetchThreadReplies(userTeam *database.UserTeam, threadTs string) ([]slack.Message, error) {
+	var replies []slack.Message
+	var cursor string
+	for {
+		var page []slack.Message
+		var hasMore bool
+		var nextCursor string
+		err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+			page, hasMore, nextCursor, err = userTeam.Client().GetConversationReplies(&slack.GetConversationRepliesParameters{
+				ChannelID: portal.Key.ChannelID,
+				Timestamp: threadTs,
+				Cursor:    cursor,
+				Limit:     threadRepliesPageSize,
+			})
+			return
+		})
+		if err != nil {
+			return replies, fmt.Errorf("failed to fetch replies to %s: %w", threadTs, err)
+		}
+		for _, reply := range page {
+			// Slack includes the origin message in the thread, so skip it
+			if reply.Timestamp != threadTs {
+				replies = append(replies, reply)
+			}
+		}
+		if !hasMore || nextCursor == "" {
+			return replies, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+func (portal *Portal) convertBackfillMessage(userTeam *database.UserTeam, message *slack.Message) (ConvertedSlackMessage, bool) {
+	converted := portal.ConvertSlackMessage(userTeam, &message.Msg)
+	converted.SlackReactions = message.Reactions
+	if message.Edited != nil && converted.Event != nil {
+		addEditedMarker(converted.Event)
+	}
+	return converted, converted.Event != nil || len(converted.FileAttachments) != 0
+}
+
+// addEditedMarker marks a backfilled message as edited, as Slack doesn't provide the edit history.
+func addEditedMarker(content *event.MessageEventContent) {
+	if content.MsgType != event.MsgText && content.MsgType != event.MsgNotice && content.MsgType != event.MsgEmote {
+		return
+	}
+	if content.Format != event.FormatHTML {
+		content.Format = event.FormatHTML
+		content.FormattedBody = strings.ReplaceAll(html.EscapeString(content.Body), "\n", "<br>")
+	}
+	content.Body += " (edited)"
+	content.FormattedBody += " <sup>(edited)</sup>"
+}
+
+// getBackfilledEventID finds the Matrix event that reactions and thread replies to the given Slack
+// message should point at. This is the text part if there is one, or the last file otherwise.
+func (portal *Portal) getBackfilledEventID(slackTimestamp string) id.EventID {
+	if message := portal.bridge.DB.Message.GetBySlackID(portal.Key, slackTimestamp); message != nil {
+		return message.MatrixID
+	} else if attachments := portal.bridge.DB.Attachment.GetAllBySlackMessageID(portal.Key, slackTimestamp); len(attachments) > 0 {
+		return attachments[len(attachments)-1].MatrixEventID
+	}
+	return ""
+}
+
+func (portal *Portal) backfill(userTeam *database.UserTeam, messages []slack.Message, isForward bool) (*mautrix.RespBatchSend, error) {
+	return portal.backfillWithThreads(userTeam, messages, nil, isForward)
+}
+
+// backfillWithThreads is like backfill, but takes thread replies from the given map keyed by thread
+// timestamp instead of fetching them from Slack, unless the map is nil.
+func (portal *Portal) backfillWithThreads(userTeam *database.UserTeam, messages []slack.Message, threads map[string][]slack.Message, isForward bool) (*mautrix.RespBatchSend, error) {
+	if !isForward && portal.FirstEventID == "" {
+		return nil, fmt.Errorf("no first event ID saved while backfilling backwards, can't backfill")
+	}
+	isHungry := portal.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry
 	convertedMessages := []ConvertedSlackMessage{}
 	earliestBridged := ""
 
@@ -269,43 +530,187 @@ func (portal *Portal) backfill(userTeam *database.UserTeam, messages []slack.Mes
 	// Slack sends messages in the backwards order
 	for i := len(messages) - 1; i >= 0; i-- {
 		message := messages[i]
-		if message.Type == "message" && (message.SubType == "" || message.SubType == "me_message" || message.SubType == "bot_message") {
-			converted := portal.ConvertSlackMessage(userTeam, &message.Msg)
-			converted.SlackReactions = message.Reactions
-			if message.ReplyCount != 0 {
-				var err error
-				converted.SlackThread, _, _, err = userTeam.Client.GetConversationReplies(&slack.GetConversationRepliesParameters{
-					ChannelID: portal.Key.ChannelID,
-					Timestamp: converted.SlackTimestamp,
-				})
-				if err != nil {
-					portal.log.Warnfln("Error when fetching thread for message %s: %v", converted.SlackTimestamp, err)
-					converted.SlackThread = nil
-				} else if len(converted.SlackThread) != 0 {
-					// Slack includes the origin message in the thread, so skip it
-					converted.SlackThread = converted.SlackThread[1:]
-					threadInfos[converted.SlackTimestamp] = SlackThreadInfo{
-						ThreadOrigin: *portal.getLastEventID(&converted),
-						ThreadLatest: *portal.getLastEventID(&converted),
-					}
-				}
+		if !isBackfillableMessage(&message) {
+			continue
+		}
+		converted, ok := portal.convertBackfillMessage(userTeam, &message)
+		if !ok {
+			continue
+		}
+		if threads != nil {
+			converted.SlackThread = threads[converted.SlackTimestamp]
+		} else if message.ReplyCount != 0 {
+			replies, err := portal.fetchThreadReplies(userTeam, converted.SlackTimestamp)
+			if err != nil {
+				portal.log.Warnln("Error when fetching thread for backfill:", err)
 			}
-			if converted.Event != nil || len(converted.FileAttachments) != 0 {
-				convertedMessages = append(convertedMessages, converted)
-				if portal.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry {
-					// Add all thread replies to this message also to convertedMessages
-					for _, reply := range converted.SlackThread {
-						convertedMessages = append(convertedMessages, portal.ConvertSlackMessage(userTeam, &reply.Msg))
-					}
+			for _, reply := range replies {
+				if isBackfillableMessage(&reply) {
+					converted.SlackThread = append(converted.SlackThread, reply)
 				}
-				if parseSlackTimestamp(converted.SlackTimestamp).Before(parseSlackTimestamp(earliestBridged)) {
-					earliestBridged = converted.SlackTimestamp
+			}
+		}
+		convertedMessages = append(convertedMessages, converted)
+		if isHungry && len(converted.SlackThread) != 0 {
+			// Event IDs are deterministic on hungryserv, so thread replies can go in the same batch
+			threadInfos[converted.SlackTimestamp] = SlackThreadInfo{
+				ThreadOrigin: *portal.getLastEventID(&converted),
+				ThreadLatest: *portal.getLastEventID(&converted),
+			}
+			for _, reply := range converted.SlackThread {
+				if convertedReply, ok := portal.convertBackfillMessage(userTeam, &reply); ok {
+					convertedMessages = append(convertedMessages, convertedReply)
 				}
 			}
 		}
+		if earliestBridged == "" || parseSlackTimestamp(converted.SlackTimestamp).Before(parseSlackTimestamp(earliestBridged)) {
+			earliestBridged = converted.SlackTimestamp
+		}
+	}
+
+	markRead := false
+	if isForward && len(convertedMessages) > 0 {
+		// The thread reply batch on other servers is part of the same pass, so this is only done once
+		portal.log.Debugln("Sending a dummy event to avoid forward extremity errors with backfill")
+		_, err := portal.MainIntent().SendMessageEvent(portal.MXID, PreBackfillDummyEvent, struct{}{})
+		if err != nil {
+			portal.log.Warnln("Error sending pre-backfill dummy event:", err)
+		}
+		markRead = portal.shouldMarkForwardBackfillRead(userTeam, convertedMessages[len(convertedMessages)-1].SlackTimestamp)
 	}
 
+	resp, err := portal.sendBackfillBatch(userTeam, convertedMessages, threadInfos, isForward, markRead, earliestBridged)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	if !isHungry {
+		portal.backfillReactions(userTeam, convertedMessages)
+		portal.backfillThreads(userTeam, convertedMessages, isForward, markRead)
+	}
+	return resp, nil
+}
+
+// shouldMarkForwardBackfillRead checks whether the user has read the Slack conversation up to the given message.
+func (portal *Portal) shouldMarkForwardBackfillRead(userTeam *database.UserTeam, lastTimestamp string) bool {
+	if portal.isOlderThanUnreadThreshold(lastTimestamp) {
+		return true
+	}
+	var conversationInfo *slack.Channel
+	err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+		conversationInfo, err = userTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{
+			ChannelID: portal.Key.ChannelID,
+		})
+		return
+	})
+	if err != nil {
+		portal.log.Warnfln("Failed to get read position to decide if backfilled messages should be marked as read: %v", err)
+		return false
+	} else if conversationInfo.LastRead == "" {
+		return false
+	}
+	return !parseSlackTimestamp(conversationInfo.LastRead).Before(parseSlackTimestamp(lastTimestamp))
+}
+
+// backfillReactions sends the reactions to messages in an already sent batch. Including reactions in
+// the batch itself requires knowing the event IDs of the messages in advance, which only works on hungryserv.
+func (portal *Portal) backfillReactions(userTeam *database.UserTeam, convertedMessages []ConvertedSlackMessage) {
 	for _, converted := range convertedMessages {
+		if len(converted.SlackReactions) == 0 {
+			continue
+		}
+		targetEventID := portal.getBackfilledEventID(converted.SlackTimestamp)
+		if targetEventID == "" {
+			portal.log.Warnfln("Not backfilling reactions to %s: message wasn't bridged", converted.SlackTimestamp)
+			continue
+		}
+		// Slack doesn't say when reactions were added, so use the timestamp of the message
+		ts := parseSlackTimestamp(converted.SlackTimestamp).UnixMilli()
+		for _, reaction := range converted.SlackReactions {
+			for _, user := range reaction.Users {
+				if portal.bridge.DB.Reaction.GetBySlackID(portal.Key, user, converted.SlackTimestamp, reaction.Name) == nil {
+					portal.sendBackfilledReaction(userTeam, targetEventID, converted.SlackTimestamp, user, reaction.Name, ts)
+				}
+			}
+		}
+	}
+}
+
+func (portal *Portal) sendBackfilledReaction(userTeam *database.UserTeam, targetEventID id.EventID, slackMessageID, slackUserID, reaction string, ts int64) {
+	reactionPuppet := portal.bridge.GetPuppetByID(portal.Key.TeamID, slackUserID)
+	if reactionPuppet == nil {
+		portal.log.Errorfln("Not backfilling reaction: can't find puppet for Slack user %s", slackUserID)
+		return
+	}
+	reactionPuppet.UpdateInfo(userTeam, true, nil)
+	content, customURL := portal.convertSlackReactionToMatrix(targetEventID, reaction, userTeam)
+	resp, err := reactionPuppet.IntentFor(portal).SendMassagedMessageEvent(portal.MXID, event.EventReaction, content, ts)
+	if err != nil {
+		portal.log.Errorfln("Failed to backfill reaction %s to %s: %v", reaction, slackMessageID, err)
+		return
+	}
+
+	dbReaction := portal.bridge.DB.Reaction.New()
+	dbReaction.Channel = portal.Key
+	dbReaction.SlackMessageID = slackMessageID
+	dbReaction.MatrixEventID = resp.EventID
+	dbReaction.AuthorID = slackUserID
+	dbReaction.MatrixName = content.AsReaction().RelatesTo.Key
+	dbReaction.MatrixURL = customURL
+	dbReaction.SlackName = reaction
+	dbReaction.Insert(nil)
+}
+
+// backfillThreads sends the replies to threads in an already sent batch as a separate batch.
+// This is needed on homeservers where event IDs aren't known before the batch is sent, as the
+// thread relations must point at the real event ID of the thread root. The batch continues the
+// backfill pass of the roots, so it reuses its read state instead of preparing a new pass.
+func (portal *Portal) backfillThreads(userTeam *database.UserTeam, roots []ConvertedSlackMessage, isForward, markRead bool) {
+	threadInfos := make(map[string]SlackThreadInfo)
+	var replies []ConvertedSlackMessage
+	for _, root := range roots {
+		if len(root.SlackThread) == 0 {
+			continue
+		}
+		rootEventID := portal.getBackfilledEventID(root.SlackTimestamp)
+		if rootEventID == "" {
+			portal.log.Warnfln("Not backfilling replies to %s: thread root wasn't bridged", root.SlackTimestamp)
+			continue
+		}
+		threadInfos[root.SlackTimestamp] = SlackThreadInfo{
+			ThreadOrigin: rootEventID,
+			ThreadLatest: rootEventID,
+		}
+		for _, reply := range root.SlackThread {
+			if convertedReply, ok := portal.convertBackfillMessage(userTeam, &reply); ok {
+				replies = append(replies, convertedReply)
+			}
+		}
+	}
+	if len(replies) == 0 {
+		return
+	}
+	portal.log.Debugfln("Backfilling %d thread replies", len(replies))
+	_, err := portal.sendBackfillBatch(userTeam, replies, threadInfos, isForward, markRead, "")
+	if err != nil {
+		portal.log.Errorfln("Error backfilling thread replies: %v", err)
+		return
+	}
+	portal.backfillReactions(userTeam, replies)
+}
+
+func (portal *Portal) sendBackfillBatch(userTeam *database.UserTeam, convertedMessages []ConvertedSlackMessage, threadInfos map[string]SlackThreadInfo, isForward, markRead bool, earliestBridged string) (*mautrix.RespBatchSend, error) {
+	req := mautrix.ReqBatchSend{
+		Events:             []*event.Event{},
+		StateEventsAtStart: []*event.Event{},
+	}
+	if !isForward {
+		req.PrevEventID = portal.FirstEventID
+	}
+	addedMembers := make(map[id.UserID]*Puppet)
+
+	for i := range convertedMessages {
+		converted := &convertedMessages[i]
+		converted.BatchReactions = nil
 		ts := parseSlackTimestamp(converted.SlackTimestamp).UnixMilli()
 		puppet := portal.bridge.GetPuppetByID(portal.Key.TeamID, converted.SlackAuthor)
 		puppet.UpdateInfo(userTeam, true, nil)
@@ -317,59 +722,43 @@ func (portal *Portal) backfill(userTeam *database.UserTeam, messages []slack.Mes
 		}
 		intent := puppet.IntentFor(portal)
 		for i, file := range converted.FileAttachments {
-			e := portal.makeBackfillEvent(intent, file.Event, fmt.Sprintf("file%d", i), &converted, &threadInfos)
+			e := portal.makeBackfillEvent(intent, file.Event, fmt.Sprintf("file%d", i), converted, &threadInfos)
 			req.Events = append(req.Events, e)
 		}
 		if converted.Event != nil {
-			e := portal.makeBackfillEvent(intent, converted.Event, "text", &converted, &threadInfos)
+			e := portal.makeBackfillEvent(intent, converted.Event, "text", converted, &threadInfos)
 			req.Events = append(req.Events, e)
 		}
 		// Sending reactions in the same batch requires deterministic event IDs, so only do it on hungryserv
 		if portal.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry {
 			for _, reaction := range converted.SlackReactions {
-				slackReaction := strings.Trim(reaction.Name, ":")
-				emoji := portal.bridge.GetEmoji(slackReaction, userTeam)
-
-				originalEventID := portal.getLastEventID(&converted)
+				originalEventID := portal.getLastEventID(converted)
 				if originalEventID == nil {
 					portal.log.Errorln("No converted event to react to!")
 					continue
 				}
 				for _, user := range reaction.Users {
-					var content event.ReactionEventContent
-					content.RelatesTo = event.RelatesTo{
-						Type:    event.RelAnnotation,
-						EventID: *originalEventID,
-						Key:     emoji,
-					}
-					extraContent := map[string]any{}
-					if strings.HasPrefix(emoji, "mxc://") {
-						extraContent["fi.mau.slack.reaction"] = map[string]any{
-							"name": slackReaction,
-							"mxc":  emoji,
-						}
-						if !portal.bridge.Config.Bridge.CustomEmojiReactions {
-							content.RelatesTo.Key = slackReaction
-						}
-					}
+					content, customURL := portal.convertSlackReactionToMatrix(*originalEventID, reaction.Name, userTeam)
 					reactionPuppet := portal.bridge.GetPuppetByID(portal.Key.TeamID, user)
 					if reactionPuppet == nil {
 						portal.log.Errorfln("Not backfilling reaction: can't find puppet for Slack user %s", user)
 						continue
 					}
 					reactionPuppet.UpdateInfo(userTeam, true, nil)
-					eventContent := event.Content{
-						Raw:    map[string]interface{}{},
-						Parsed: content,
-					}
 					if reactionPuppet.CustomMXID != "" {
-						eventContent.Raw[doublePuppetKey] = doublePuppetValue
+						content.Raw[doublePuppetKey] = doublePuppetValue
 					}
 					req.Events = append(req.Events, &event.Event{
 						Sender:    reactionPuppet.GetCustomOrGhostMXID(),
 						Type:      event.EventReaction,
 						Timestamp: ts,
-						Content:   eventContent,
+						Content:   *content,
+					})
+					converted.BatchReactions = append(converted.BatchReactions, BatchSlackReaction{
+						SlackAuthor: user,
+						SlackName:   reaction.Name,
+						MatrixName:  content.AsReaction().RelatesTo.Key,
+						MatrixURL:   customURL,
 					})
 				}
 			}
@@ -416,15 +805,7 @@ func (portal *Portal) backfill(userTeam *database.UserTeam, messages []slack.Mes
 
 	if isForward {
 		req.BeeperNewMessages = true
-		portal.log.Debugln("Sending a dummy event to avoid forward extremity errors with backfill")
-		_, err := portal.MainIntent().SendMessageEvent(portal.MXID, PreBackfillDummyEvent, struct{}{})
-		if err != nil {
-			portal.log.Warnln("Error sending pre-backfill dummy event:", err)
-		}
-		conversationInfo, err := userTeam.Client.GetConversationInfo(&slack.GetConversationInfoInput{
-			ChannelID: portal.Key.ChannelID,
-		})
-		if err != nil || conversationInfo.LastRead == convertedMessages[len(convertedMessages)-1].SlackTimestamp || time.Since(parseSlackTimestamp(convertedMessages[len(convertedMessages)-1].SlackTimestamp)).Hours() > float64(portal.bridge.Config.Bridge.Backfill.UnreadHoursThreshold) {
+		if markRead {
 			req.BeeperMarkReadBy = userTeam.Key.MXID
 		}
 	}
@@ -478,23 +859,24 @@ func (portal *Portal) finishBatch(txn dbutil.Transaction, eventIDs []id.EventID,
 				portal.log.Errorln("Server returned fewer event IDs than events in our batch!")
 				return
 			}
-			portal.markMessageHandled(txn, converted.SlackTimestamp, "", eventIDs[idx], converted.SlackAuthor)
+			portal.markMessageHandled(txn, converted.SlackTimestamp, converted.SlackThreadTs, converted.SlackEditTs, eventIDs[idx], converted.SlackAuthor)
 			idx += 1
 		}
-		if portal.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry {
-			for _, reaction := range converted.SlackReactions {
-				for _, user := range reaction.Users {
-					dbReaction := portal.bridge.DB.Reaction.New()
-					dbReaction.Channel = portal.Key
-					dbReaction.SlackMessageID = converted.SlackTimestamp
-					dbReaction.MatrixEventID = eventIDs[idx]
-					dbReaction.AuthorID = user
-					dbReaction.MatrixName = convertSlackReaction(reaction.Name)
-					dbReaction.SlackName = reaction.Name
-					dbReaction.Insert(txn)
-					idx += 1
-				}
+		for _, reaction := range converted.BatchReactions {
+			if idx >= len(eventIDs) {
+				portal.log.Errorln("Server returned fewer event IDs than events in our batch!")
+				return
 			}
+			dbReaction := portal.bridge.DB.Reaction.New()
+			dbReaction.Channel = portal.Key
+			dbReaction.SlackMessageID = converted.SlackTimestamp
+			dbReaction.MatrixEventID = eventIDs[idx]
+			dbReaction.AuthorID = reaction.SlackAuthor
+			dbReaction.MatrixName = reaction.MatrixName
+			dbReaction.MatrixURL = reaction.MatrixURL
+			dbReaction.SlackName = reaction.SlackName
+			dbReaction.Insert(txn)
+			idx += 1
 		}
 	}
 	portal.sendPostBackfillDummy(eventIDs[0])
@@ -519,13 +901,41 @@ func (portal *Portal) updateBackfillStatus(backfillState *database.BackfillState
 	}
 
 	_, err := portal.MainIntent().SendStateEvent(portal.MXID, BackfillStatusEvent, "", map[string]interface{}{
-		"status": backfillStatus,
+		"status":        backfillStatus,
+		"message_count": backfillState.MessageCount,
 	})
 	if err != nil {
 		portal.log.Errorln("Error sending backfill status event:", err)
 	}
 }
 
+// forwardBackfillTeam forward backfills all portals of a user team after connecting. It runs outside the
+// event loop, and only one pass runs per team at a time even if the connection flaps.
+func (user *User) forwardBackfillTeam(userTeam *database.UserTeam) {
+	teamID := userTeam.Key.TeamID
+	user.teamConnectionsLock.Lock()
+	if user.catchingUp[teamID] {
+		user.teamConnectionsLock.Unlock()
+		user.log.Debugfln("Not starting forward backfill for %s, previous one is still running", teamID)
+		return
+	}
+	user.catchingUp[teamID] = true
+	user.teamConnectionsLock.Unlock()
+	defer func() {
+		user.teamConnectionsLock.Lock()
+		delete(user.catchingUp, teamID)
+		user.teamConnectionsLock.Unlock()
+	}()
+
+	portals := user.bridge.dbPortalsToPortals(user.bridge.DB.Portal.GetAllForUserTeam(userTeam.Key))
+	for _, portal := range portals {
+		err := portal.ForwardBackfill()
+		if err != nil {
+			user.log.Warnfln("Forward backfill for portal %s failed: %v", portal.Key, err)
+		}
+	}
+}
+
 func (portal *Portal) ForwardBackfill() error {
 	portal.slackMessageLock.Lock()
 	defer portal.slackMessageLock.Unlock()
@@ -537,39 +947,26 @@ func (portal *Portal) ForwardBackfill() error {
 	}
 
 	portal.log.Infoln("Forward backfilling messages after reconnect")
-	userTeam := portal.bridge.DB.UserTeam.GetFirstUserTeamForPortal(&portal.Key)
+	userTeam := portal.getBackfillUserTeam()
 	if userTeam == nil {
 		portal.log.Errorln("Couldn't find logged in user for backfilling!")
 		return nil
 	}
-	if userTeam.CookieToken != "" {
-		userTeam.Client = slack.New(userTeam.Token, slack.OptionCookie("d", userTeam.CookieToken))
-	} else {
-		userTeam.Client = slack.New(userTeam.Token)
-	}
 
-	lastMessage := portal.bridge.DB.Message.GetLast(portal.Key)
-	lastAttachment := portal.bridge.DB.Attachment.GetLast(portal.Key)
-	var lastID string
-	if lastMessage == nil && lastAttachment == nil {
+	lastID, _ := portal.getLastBridged("")
+	if lastID == "" {
 		portal.log.Debugln("No last message for portal, can't forward backfill")
 		return nil
-	} else if lastMessage == nil {
-		lastID = lastAttachment.SlackMessageID
-	} else if lastAttachment == nil {
-		lastID = lastMessage.SlackID
-	} else {
-		if parseSlackTimestamp(lastAttachment.SlackMessageID).After(parseSlackTimestamp(lastMessage.SlackID)) {
-			lastID = lastAttachment.SlackMessageID
-		} else {
-			lastID = lastMessage.SlackID
-		}
 	}
-	messages, err := userTeam.Client.GetConversationHistory(&slack.GetConversationHistoryParameters{
-		ChannelID: portal.Key.ChannelID,
-		Oldest:    lastID,
-		Inclusive: false,
-		Limit:     portal.bridge.Config.Bridge.Backfill.ImmediateMessages,
+	var messages *slack.GetConversationHistoryResponse
+	err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+		messages, err = userTeam.Client().GetConversationHistory(&slack.GetConversationHistoryParameters{
+			ChannelID: portal.Key.ChannelID,
+			Oldest:    lastID,
+			Inclusive: false,
+			Limit:     portal.bridge.Config.Bridge.Backfill.ImmediateMessages,
+		})
+		return
 	})
 	if err != nil {
 		portal.log.Errorln("Error fetching messages for forward backfill", err)
@@ -580,7 +977,173 @@ func (portal *Portal) ForwardBackfill() error {
 		portal.log.Errorln("Error forward backfilling messages", err)
 		return err
 	}
+	return portal.catchUp(userTeam, lastID)
+}
+
+const (
+	// catchUpWindow is how far before the last bridged message the catch-up pass looks for changes.
+	catchUpWindow   = 24 * time.Hour
+	catchUpPageSize = 200
+	// catchUpMaxPages and catchUpMaxThreads limit how much history a single catch-up pass fetches.
+	catchUpMaxPages   = 10
+	catchUpMaxThreads = 50
+)
+
+func isCatchUpMessage(message *slack.Message) bool {
+	return isBackfillableMessage(message) || (message.Type == "message" && message.SubType == "thread_broadcast")
+}
+
+// catchUp reconciles recent Slack history with the message, attachment and reaction tables. This applies
+// edits, deletions, reactions and thread replies that were missed while the bridge was disconnected.
+func (portal *Portal) catchUp(userTeam *database.UserTeam, lastID string) error {
+	user := portal.bridge.GetUserByMXID(userTeam.Key.MXID)
+	oldest := fmt.Sprintf("%d.000000", parseSlackTimestamp(lastID).Add(-catchUpWindow).Unix())
+
+	var history []slack.Message
+	var cursor string
+	for page := 1; ; page++ {
+		var resp *slack.GetConversationHistoryResponse
+		err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+			resp, err = userTeam.Client().GetConversationHistory(&slack.GetConversationHistoryParameters{
+				ChannelID: portal.Key.ChannelID,
+				Oldest:    oldest,
+				Inclusive: true,
+				Cursor:    cursor,
+				Limit:     catchUpPageSize,
+			})
+			return
+		})
+		if err != nil {
+			portal.log.Errorln("Error fetching messages for catching up:", err)
+			return err
+		}
+		history = append(history, resp.Messages...)
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			break
+		} else if page >= catchUpMaxPages {
+			// Only look for deletions in the part of the history that was actually fetched
+			oldest = history[len(history)-1].Timestamp
+			portal.log.Debugfln("Catch-up reached page limit, only checking messages since %s", oldest)
+			break
+		}
+		cursor = resp.ResponseMetaData.NextCursor
+	}
+
+	seen := make(map[string]bool)
+	threads := 0
+	// Slack sends messages in the backwards order
+	for i := len(history) - 1; i >= 0; i-- {
+		message := history[i]
+		if !isCatchUpMessage(&message) {
+			continue
+		}
+		seen[message.Timestamp] = true
+		// Older messages that were never bridged are left to backfill instead of being sent at the end of the room
+		isNew := parseSlackTimestamp(message.Timestamp).After(parseSlackTimestamp(lastID))
+		if !portal.catchUpMessage(user, userTeam, &message, isNew) {
+			continue
+		}
+
+		dbReplies := portal.bridge.DB.Message.GetAllInThread(portal.Key, message.Timestamp)
+		if message.ReplyCount == 0 && len(dbReplies) == 0 {
+			continue
+		} else if threads >= catchUpMaxThreads {
+			continue
+		}
+		threads++
+		replies, err := portal.fetchThreadReplies(userTeam, message.Timestamp)
+		if err != nil {
+			// The reply list is incomplete, so missing replies can't be assumed to be deleted
+			portal.log.Warnln("Error when fetching thread for catching up:", err)
+			continue
+		}
+		// Replies that aren't bridged on their own, like thread broadcasts, are still stored in the thread
+		seenReplies := make(map[string]bool)
+		for _, reply := range replies {
+			seenReplies[reply.Timestamp] = true
+			if isCatchUpMessage(&reply) {
+				portal.catchUpMessage(user, userTeam, &reply, true)
+			}
+		}
+		for _, dbReply := range dbReplies {
+			if !seenReplies[dbReply.SlackID] {
+				portal.log.Debugfln("Thread reply %s was deleted while disconnected", dbReply.SlackID)
+				portal.redactSlackMessage(dbReply.SlackID)
+			}
+		}
+	}
+
+	deleted := make(map[string]bool)
+	for _, dbMessage := range portal.bridge.DB.Message.GetAllSince(portal.Key, oldest) {
+		if dbMessage.SlackThreadID == "" || dbMessage.SlackThreadID == dbMessage.SlackID {
+			deleted[dbMessage.SlackID] = !seen[dbMessage.SlackID]
+		}
+	}
+	for _, dbAttachment := range portal.bridge.DB.Attachment.GetAllSince(portal.Key, oldest) {
+		if dbAttachment.SlackThreadID == "" || dbAttachment.SlackThreadID == dbAttachment.SlackMessageID {
+			deleted[dbAttachment.SlackMessageID] = !seen[dbAttachment.SlackMessageID]
+		}
+	}
+	for slackID, isDeleted := range deleted {
+		if isDeleted {
+			portal.log.Debugfln("Message %s was deleted while disconnected", slackID)
+			portal.redactSlackMessage(slackID)
+		}
+	}
+	portal.SyncSlackPins(userTeam)
 	return nil
 }
 
+// catchUpMessage bridges a single message from Slack history if it's missing and bridgeMissing is set,
+// or otherwise applies any edits and reaction changes that haven't been bridged yet.
+// The return value is whether the message exists on Matrix after the catch-up.
+func (portal *Portal) catchUpMessage(user *User, userTeam *database.UserTeam, message *slack.Message, bridgeMissing bool) bool {
+	targetEventID := portal.getBackfilledEventID(message.Timestamp)
+	if targetEventID == "" {
+		if !bridgeMissing {
+			return false
+		}
+		portal.log.Debugfln("Bridging message %s that was missed while disconnected", message.Timestamp)
+		portal.HandleSlackNormalMessage(user, userTeam, &message.Msg, nil)
+		targetEventID = portal.getBackfilledEventID(message.Timestamp)
+		if targetEventID == "" {
+			return false
+		}
+	} else if message.Edited != nil {
+		dbMessage := portal.bridge.DB.Message.GetBySlackID(portal.Key, message.Timestamp)
+		// Messages bridged before edit timestamps were stored don't have one, so it's unknown whether the edit was bridged
+		if dbMessage != nil && dbMessage.EditTimestamp != database.UnknownEditTimestamp && dbMessage.EditTimestamp != message.Edited.Timestamp {
+			portal.log.Debugfln("Bridging edit of %s that was missed while disconnected", message.Timestamp)
+			portal.HandleSlackNormalMessage(user, userTeam, &message.Msg, dbMessage)
+		}
+	}
+
+	existingReactions := make(map[string]*database.Reaction)
+	for _, dbReaction := range portal.bridge.DB.Reaction.GetAllBySlackMessageID(portal.Key, message.Timestamp) {
+		existingReactions[dbReaction.SlackName+"/"+dbReaction.AuthorID] = dbReaction
+	}
+	// Slack doesn't say when reactions were added, so use the timestamp of the message
+	ts := parseSlackTimestamp(message.Timestamp).UnixMilli()
+	completeReactions := make(map[string]bool)
+	for _, reaction := range message.Reactions {
+		// The user list is truncated for popular reactions, so missing users don't mean removed reactions
+		completeReactions[reaction.Name] = len(reaction.Users) >= reaction.Count
+		for _, slackUserID := range reaction.Users {
+			key := reaction.Name + "/" + slackUserID
+			if _, ok := existingReactions[key]; ok {
+				delete(existingReactions, key)
+			} else {
+				portal.sendBackfilledReaction(userTeam, targetEventID, message.Timestamp, slackUserID, reaction.Name, ts)
+			}
+		}
+	}
+	for _, dbReaction := range existingReactions {
+		complete, found := completeReactions[dbReaction.SlackName]
+		if !found || complete {
+			portal.redactSlackReaction(userTeam, dbReaction)
+		}
+	}
+	return true
+}
+
 // endregion
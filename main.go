@@ -18,10 +18,16 @@ package main
 
 import (
 	_ "embed"
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
 
 	"maunium.net/go/mautrix/bridge"
+	"maunium.net/go/mautrix/bridge/bridgeconfig"
 	"maunium.net/go/mautrix/bridge/commands"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/util/configupgrade"
@@ -51,6 +57,8 @@ type SlackBridge struct {
 
 	MatrixHTMLParser *format.HTMLParser
 
+	appEvents *appEventDispatcher
+
 	BackfillQueue          *BackfillQueue
 	historySyncLoopStarted bool
 
@@ -68,6 +76,14 @@ type SlackBridge struct {
 	puppets             map[string]*Puppet
 	puppetsByCustomMXID map[id.UserID]*Puppet
 	puppetsLock         sync.Mutex
+
+	blockImages *blockImageCache
+
+	emojiRefreshes     map[string]time.Time
+	emojiRefreshesLock sync.Mutex
+
+	pendingChannelCreates     map[string]struct{} // the key is teamID-channelName
+	pendingChannelCreatesLock sync.Mutex
 }
 
 func (br *SlackBridge) GetExampleConfig() string {
@@ -85,6 +101,9 @@ func (br *SlackBridge) GetConfigPtr() interface{} {
 func (br *SlackBridge) Init() {
 	br.CommandProcessor = commands.NewProcessor(&br.Bridge)
 	br.RegisterCommands()
+	br.EventProcessor.On(event.StatePinnedEvents, br.HandleMatrixPinnedEvents)
+	br.EventProcessor.On(event.StateMember, br.HandleMatrixBan)
+	br.EventProcessor.On(event.EphemeralEventPresence, br.HandleMatrixPresence)
 
 	br.DB = database.New(br.Bridge.DB, br.Log.Sub("Database"))
 
@@ -100,10 +119,15 @@ func (br *SlackBridge) Start() {
 		BackfillQuery:   br.DB.Backfill,
 		reCheckChannels: []chan bool{},
 		log:             br.Log.Sub("BackfillQueue"),
+		teamWorkers:     make(map[string]bool),
+		rateLimiters:    make(map[string]*rateLimiter),
 	}
 
+	br.startAppEvents()
+
 	br.WaitWebsocketConnected()
 	go br.startUsers()
+	go br.refreshPresenceLoop()
 }
 
 func (br *SlackBridge) Stop() {
@@ -142,8 +166,58 @@ func (br *SlackBridge) GetIGhost(mxid id.UserID) bridge.Ghost {
 	return p
 }
 
-func (br *SlackBridge) CreatePrivatePortal(id id.RoomID, user bridge.User, ghost bridge.Ghost) {
-	//TODO implement
+// HandleMatrixBan removes banned ghosts from the Slack channel. Other membership changes are
+// handled by the bridge module, which doesn't pass bans on to portals.
+func (br *SlackBridge) HandleMatrixBan(evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.MemberEventContent)
+	if !ok || content.Membership != event.MembershipBan || evt.Sender == br.Bot.UserID || br.IsGhost(evt.Sender) {
+		return
+	}
+	ghost := br.GetPuppetByMXID(id.UserID(evt.GetStateKey()))
+	portal := br.GetPortalByMXID(evt.RoomID)
+	if ghost == nil || portal == nil {
+		return
+	}
+	user := br.GetUserByMXID(evt.Sender)
+	if user == nil || user.PermissionLevel < bridgeconfig.PermissionLevelUser || !user.IsLoggedIn() {
+		return
+	}
+	portal.HandleMatrixKick(user, ghost)
+}
+
+func (br *SlackBridge) CreatePrivatePortal(roomID id.RoomID, brInviter bridge.User, brGhost bridge.Ghost) {
+	inviter := brInviter.(*User)
+	puppet := brGhost.(*Puppet)
+	intent := puppet.DefaultIntent()
+
+	userTeam := inviter.GetUserTeam(puppet.TeamID)
+	if userTeam == nil || userTeam.Client() == nil {
+		_, _ = intent.SendNotice(roomID, "You're not logged into the Slack team of this user")
+		_, _ = intent.LeaveRoom(roomID)
+		return
+	}
+
+	channel, _, _, err := userTeam.Client().OpenConversation(&slack.OpenConversationParameters{
+		Users:    []string{puppet.UserID},
+		ReturnIM: true,
+	})
+	if err != nil {
+		br.Log.Errorfln("Failed to open Slack DM with %s for %s: %v", puppet.UserID, inviter.MXID, err)
+		_, _ = intent.SendNotice(roomID, fmt.Sprintf("Failed to open DM on Slack: %v", err))
+		_, _ = intent.LeaveRoom(roomID)
+		return
+	}
+
+	portal := br.GetPortalByID(database.NewPortalKey(puppet.TeamID, channel.ID))
+	if portal.MXID == "" {
+		portal.createPrivatePortalFromInvite(roomID, inviter, userTeam, puppet)
+		return
+	}
+
+	portal.ensureUserInvited(inviter)
+	portal.InsertUser(userTeam.Key)
+	_, _ = intent.SendNotice(roomID, fmt.Sprintf("You already have a private chat portal with me at %s", portal.MXID.URI(br.AS.HomeserverDomain).MatrixToURL()))
+	_, _ = intent.LeaveRoom(roomID)
 }
 
 func main() {
@@ -158,6 +232,12 @@ func main() {
 
 		puppets:             make(map[string]*Puppet),
 		puppetsByCustomMXID: make(map[id.UserID]*Puppet),
+
+		blockImages: newBlockImageCache(maxCachedBlockImages),
+
+		emojiRefreshes: make(map[string]time.Time),
+
+		pendingChannelCreates: make(map[string]struct{}),
 	}
 	br.Bridge = bridge.Bridge{
 		Name:              "mautrix-slack",
@@ -48,6 +48,7 @@ var (
 	errTargetIsFake                = errors.New("target is a fake event")
 	errReactionSentBySomeoneElse   = errors.New("target reaction was sent by someone else")
 	errDMSentByOtherUser           = errors.New("target message was sent by the other user in a DM")
+	errEditSentBySomeoneElse       = errors.New("relayed edits can only target messages sent by the same user")
 
 	errMessageTakingLong     = errors.New("bridging the message is taking longer than usual")
 	errTimeoutBeforeHandling = errors.New("message timed out before handling was started")
@@ -73,7 +74,8 @@ func errorToStatusReason(err error) (reason event.MessageStatusReason, status ev
 		errors.Is(err, errReactionDatabaseNotFound),
 		errors.Is(err, errReactionTargetNotFound),
 		errors.Is(err, errReactionSentBySomeoneElse),
-		errors.Is(err, errDMSentByOtherUser):
+		errors.Is(err, errDMSentByOtherUser),
+		errors.Is(err, errEditSentBySomeoneElse):
 		return event.MessageStatusGenericError, event.MessageStatusFail, true, false, ""
 	default:
 		return event.MessageStatusGenericError, event.MessageStatusRetriable, false, true, ""
@@ -159,6 +161,12 @@ func (portal *Portal) sendMessageMetrics(evt *event.Event, err error, part strin
 		msgType = "reaction"
 	case event.EventRedaction:
 		msgType = "redaction"
+	case event.StatePinnedEvents:
+		msgType = "pinned events change"
+	case event.StateRoomName:
+		msgType = "room name change"
+	case event.StateTopic:
+		msgType = "topic change"
 	default:
 		msgType = "unknown event"
 	}
@@ -0,0 +1,259 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2022 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/exp/slices"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridge/bridgeconfig"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/database"
+)
+
+// HandleMatrixPinnedEvents passes changes to the pinned events of portals to the portal's Matrix event loop.
+func (br *SlackBridge) HandleMatrixPinnedEvents(evt *event.Event) {
+	if evt.Sender == br.Bot.UserID || br.IsGhost(evt.Sender) {
+		return
+	}
+	user := br.GetUserByMXID(evt.Sender)
+	if user == nil || user.PermissionLevel < bridgeconfig.PermissionLevelUser || !user.IsLoggedIn() {
+		return
+	}
+	portal := br.GetPortalByMXID(evt.RoomID)
+	if portal != nil {
+		portal.ReceiveMatrixEvent(user, evt)
+	}
+}
+
+// getPinnedEvents returns the current pinned events of the portal room.
+func (portal *Portal) getPinnedEvents() ([]id.EventID, error) {
+	var content event.PinnedEventsEventContent
+	err := portal.MainIntent().StateEvent(portal.MXID, event.StatePinnedEvents, "", &content)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, nil
+	}
+	return content.Pinned, err
+}
+
+func (portal *Portal) setPinnedEvents(pinned []id.EventID) {
+	_, err := portal.MainIntent().SendStateEvent(portal.MXID, event.StatePinnedEvents, "", &event.PinnedEventsEventContent{Pinned: pinned})
+	if err != nil {
+		portal.log.Warnln("Failed to update pinned events:", err)
+	}
+}
+
+// getSlackItemEventID finds the Matrix event of a Slack message. Messages that only contained files
+// are represented by their first attachment.
+func (portal *Portal) getSlackItemEventID(slackID string) id.EventID {
+	message := portal.bridge.DB.Message.GetBySlackID(portal.Key, slackID)
+	if message != nil {
+		return message.MatrixID
+	}
+	attachments := portal.bridge.DB.Attachment.GetAllBySlackMessageID(portal.Key, slackID)
+	if len(attachments) > 0 {
+		return attachments[0].MatrixEventID
+	}
+	return ""
+}
+
+// getMatrixEventSlackID finds the Slack message that a Matrix event was bridged from or to.
+func (portal *Portal) getMatrixEventSlackID(eventID id.EventID) string {
+	message := portal.bridge.DB.Message.GetByMatrixID(portal.Key, eventID)
+	if message != nil {
+		return message.SlackID
+	}
+	attachment := portal.bridge.DB.Attachment.GetByMatrixID(portal.Key, eventID)
+	if attachment != nil {
+		return attachment.SlackMessageID
+	}
+	return ""
+}
+
+// HandleSlackPin adds or removes a pinned Slack message from the pinned events of the portal room.
+func (portal *Portal) HandleSlackPin(item slack.Item, pinned bool) {
+	if portal.MXID == "" || item.Type != slack.TYPE_MESSAGE || item.Message == nil {
+		return
+	}
+	eventID := portal.getSlackItemEventID(item.Message.Timestamp)
+	if eventID == "" {
+		portal.log.Debugfln("Ignoring pin change of unknown message %s", item.Message.Timestamp)
+		return
+	}
+
+	portal.pinLock.Lock()
+	defer portal.pinLock.Unlock()
+	current, err := portal.getPinnedEvents()
+	if err != nil {
+		portal.log.Warnln("Failed to get pinned events:", err)
+		return
+	}
+	index := slices.Index(current, eventID)
+	if pinned && index < 0 {
+		portal.log.Debugfln("Pinning %s (%s)", eventID, item.Message.Timestamp)
+		portal.setPinnedEvents(append(current, eventID))
+	} else if !pinned && index >= 0 {
+		portal.log.Debugfln("Unpinning %s (%s)", eventID, item.Message.Timestamp)
+		portal.setPinnedEvents(slices.Delete(current, index, index+1))
+	}
+}
+
+// SyncSlackPins replaces the pinned Slack messages in the portal room with the messages pinned on Slack.
+// Pinned events that weren't bridged from or to Slack are kept.
+// getSlackPinnedEvents fetches the messages that are pinned on Slack and returns their Matrix event IDs.
+// Pinned messages that weren't bridged are left out.
+func (portal *Portal) getSlackPinnedEvents(userTeam *database.UserTeam) ([]id.EventID, error) {
+	var items []slack.Item
+	err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+		items, _, err = userTeam.Client().ListPins(portal.Key.ChannelID)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	var slackPinned []id.EventID
+	for _, item := range items {
+		if item.Type != slack.TYPE_MESSAGE || item.Message == nil {
+			continue
+		}
+		eventID := portal.getSlackItemEventID(item.Message.Timestamp)
+		if eventID != "" && !slices.Contains(slackPinned, eventID) {
+			slackPinned = append(slackPinned, eventID)
+		}
+	}
+	return slackPinned, nil
+}
+
+func (portal *Portal) SyncSlackPins(userTeam *database.UserTeam) {
+	if portal.MXID == "" {
+		return
+	}
+	// Hold the lock while fetching, so that pins being bridged from Matrix aren't removed again
+	portal.pinLock.Lock()
+	defer portal.pinLock.Unlock()
+	slackPinned, err := portal.getSlackPinnedEvents(userTeam)
+	if err != nil {
+		portal.log.Warnln("Failed to fetch pinned messages:", err)
+		return
+	}
+	current, err := portal.getPinnedEvents()
+	if err != nil {
+		portal.log.Warnln("Failed to get pinned events:", err)
+		return
+	}
+	// Keep the existing order, and only drop pins of Slack messages that aren't pinned on Slack anymore
+	pinned := make([]id.EventID, 0, len(current)+len(slackPinned))
+	for _, eventID := range current {
+		if slices.Contains(slackPinned, eventID) || portal.getMatrixEventSlackID(eventID) == "" {
+			pinned = append(pinned, eventID)
+		}
+	}
+	for _, eventID := range slackPinned {
+		if !slices.Contains(pinned, eventID) {
+			pinned = append(pinned, eventID)
+		}
+	}
+	if slices.Equal(current, pinned) {
+		return
+	}
+	portal.log.Debugfln("Syncing %d pinned messages from Slack", len(pinned))
+	portal.setPinnedEvents(pinned)
+}
+
+func pinnedEventsDiff(prev, new []id.EventID) (added, removed []id.EventID) {
+	for _, eventID := range new {
+		if !slices.Contains(prev, eventID) {
+			added = append(added, eventID)
+		}
+	}
+	for _, eventID := range prev {
+		if !slices.Contains(new, eventID) {
+			removed = append(removed, eventID)
+		}
+	}
+	return
+}
+
+func (portal *Portal) handleMatrixPinnedEvents(sender *User, evt *event.Event) {
+	userTeam := sender.GetUserTeam(portal.Key.TeamID)
+	if userTeam == nil || userTeam.Client() == nil {
+		go portal.sendMessageMetrics(evt, errUserNotLoggedIn, "Ignoring", nil)
+		return
+	}
+	content, ok := evt.Content.Parsed.(*event.PinnedEventsEventContent)
+	if !ok {
+		go portal.sendMessageMetrics(evt, errUnexpectedParsedContentType, "Ignoring", nil)
+		return
+	}
+	// Slack pin events and pin syncs must not apply stale pin lists while the change is being sent
+	portal.pinLock.Lock()
+	defer portal.pinLock.Unlock()
+
+	var prevPinned []id.EventID
+	hasPrevContent := false
+	if evt.Unsigned.PrevContent != nil {
+		_ = evt.Unsigned.PrevContent.ParseRaw(evt.Type)
+		if prevContent, ok := evt.Unsigned.PrevContent.Parsed.(*event.PinnedEventsEventContent); ok {
+			prevPinned = prevContent.Pinned
+			hasPrevContent = true
+		}
+	}
+	if !hasPrevContent {
+		// Without the previous list, compare against what's pinned on Slack, so that unpins aren't missed
+		var err error
+		prevPinned, err = portal.getSlackPinnedEvents(userTeam)
+		if err != nil {
+			portal.log.Warnfln("Failed to fetch Slack pins to diff %s against, only pinning new messages: %v", evt.ID, err)
+		}
+	}
+	added, removed := pinnedEventsDiff(prevPinned, content.Pinned)
+	portal.log.Debugfln("Received pinned events change %s from %s: %d pinned, %d unpinned", evt.ID, evt.Sender, len(added), len(removed))
+	var sendErr error
+	for _, eventID := range added {
+		slackID := portal.getMatrixEventSlackID(eventID)
+		if slackID == "" {
+			continue
+		}
+		err := userTeam.Client().AddPin(portal.Key.ChannelID, slack.NewRefToMessage(portal.Key.ChannelID, slackID))
+		if err != nil && err.Error() != "already_pinned" {
+			portal.log.Warnfln("Failed to pin %s (%s) on Slack: %v", eventID, slackID, err)
+			if sendErr == nil {
+				sendErr = fmt.Errorf("failed to pin message: %w", err)
+			}
+		}
+	}
+	for _, eventID := range removed {
+		slackID := portal.getMatrixEventSlackID(eventID)
+		if slackID == "" {
+			continue
+		}
+		err := userTeam.Client().RemovePin(portal.Key.ChannelID, slack.NewRefToMessage(portal.Key.ChannelID, slackID))
+		if err != nil && err.Error() != "no_pin" {
+			portal.log.Warnfln("Failed to unpin %s (%s) on Slack: %v", eventID, slackID, err)
+			if sendErr == nil {
+				sendErr = fmt.Errorf("failed to unpin message: %w", err)
+			}
+		}
+	}
+	go portal.sendMessageMetrics(evt, sendErr, "Error sending", nil)
+}
@@ -26,6 +26,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"golang.org/x/exp/slices"
 	log "maunium.net/go/maulogger/v2"
@@ -64,6 +65,7 @@ type Portal struct {
 	matrixMessages chan portalMatrixMessage
 
 	slackMessageLock sync.Mutex
+	pinLock          sync.Mutex
 
 	currentlyTyping     []id.UserID
 	currentlyTypingLock sync.Mutex
@@ -73,8 +75,8 @@ var (
 	_ bridge.Portal                    = (*Portal)(nil)
 	_ bridge.ReadReceiptHandlingPortal = (*Portal)(nil)
 	_ bridge.TypingPortal              = (*Portal)(nil)
-	//_ bridge.MembershipHandlingPortal = (*Portal)(nil)
-	//_ bridge.MetaHandlingPortal = (*Portal)(nil)
+	_ bridge.MembershipHandlingPortal  = (*Portal)(nil)
+	_ bridge.MetaHandlingPortal        = (*Portal)(nil)
 	//_ bridge.DisappearingPortal = (*Portal)(nil)
 )
 
@@ -94,11 +96,40 @@ func (portal *Portal) shouldSetDMRoomMetadata() bool {
 }
 
 func (portal *Portal) ReceiveMatrixEvent(user bridge.User, evt *event.Event) {
-	if user.GetPermissionLevel() >= bridgeconfig.PermissionLevelUser /*|| portal.HasRelaybot()*/ {
+	if user.GetPermissionLevel() >= bridgeconfig.PermissionLevelUser || portal.HasRelaybot() {
 		portal.matrixMessages <- portalMatrixMessage{user: user.(*User), evt: evt, receivedAt: time.Now()}
 	}
 }
 
+func (portal *Portal) HasRelaybot() bool {
+	return portal.bridge.Config.Bridge.Relay.Enabled && portal.RelayUserID != ""
+}
+
+func (portal *Portal) getRelayUserTeam() *database.UserTeam {
+	if !portal.HasRelaybot() {
+		return nil
+	}
+	relayUser := portal.bridge.GetUserByMXID(portal.RelayUserID)
+	if relayUser == nil {
+		return nil
+	}
+	return relayUser.GetUserTeam(portal.Key.TeamID)
+}
+
+// canRelayOverrideSender checks if the relay user's token is allowed to set a custom
+// username and icon when posting messages. Only bot tokens support that.
+func (portal *Portal) canRelayOverrideSender(userTeam *database.UserTeam) bool {
+	return strings.HasPrefix(userTeam.Token, "xoxb")
+}
+
+func (portal *Portal) getRelaySender(sender *User) event.MemberEventContent {
+	member := portal.MainIntent().Member(portal.MXID, sender.MXID)
+	if member == nil {
+		return event.MemberEventContent{}
+	}
+	return *member
+}
+
 func (portal *Portal) HandleMatrixReadReceipt(sender bridge.User, eventID id.EventID, receipt event.ReadReceipt) {
 	//portal.handleMatrixReadReceipt(sender.(*User), eventID, receiptTimestamp, true)
 	userTeam := sender.(*User).GetUserTeam(portal.Key.TeamID)
@@ -118,7 +149,7 @@ func (portal *Portal) markSlackRead(user *User, userTeam *database.UserTeam, eve
 		return
 	}
 
-	userTeam.Client.MarkConversation(portal.Key.ChannelID, message.SlackID)
+	userTeam.Client().MarkConversation(portal.Key.ChannelID, message.SlackID)
 	portal.log.Debugfln("Marked message %s as read by %s in portal %s", message.SlackID, user.MXID, portal.Key)
 }
 
@@ -259,10 +290,13 @@ func (portal *Portal) MainIntent() *appservice.IntentAPI {
 func (portal *Portal) syncParticipants(source *User, sourceTeam *database.UserTeam, participants []string, invite bool) []id.UserID {
 	userIDs := make([]id.UserID, 0, len(participants)+1)
 	for _, participant := range participants {
-		portal.log.Infofln("Getting participant %s", participant)
+		portal.log.Debugfln("Getting participant %s", participant)
 		puppet := portal.bridge.GetPuppetByID(sourceTeam.Key.TeamID, participant)
 
-		puppet.UpdateInfo(sourceTeam, true, nil)
+		// Profile changes of known users arrive as user_change events, so only fetch the info of new ghosts
+		if puppet.Name == "" || !puppet.NameSet {
+			puppet.UpdateInfo(sourceTeam, true, nil)
+		}
 
 		user := portal.bridge.GetUserByID(sourceTeam.Key.TeamID, participant)
 
@@ -307,17 +341,24 @@ func (portal *Portal) CreateMatrixRoom(user *User, userTeam *database.UserTeam,
 	// If we have a matrix id the room should exist so we have nothing to do.
 	if portal.MXID != "" {
 		return nil
+	} else if portal.Unbridged {
+		return errPortalUnbridged
 	}
 
 	channel = portal.UpdateInfo(user, userTeam, channel, false)
 	if channel == nil {
 		return fmt.Errorf("didn't find channel metadata")
+	} else if portal.bridge.isChannelCreatePending(portal.Key.TeamID, channel.Name) {
+		return errChannelBeingBridged
 	}
 	typeFound := portal.setChannelType(channel)
 	if !typeFound {
 		portal.log.Warnln("No appropriate type found for channel")
 		return nil
 	}
+	if portal.Type == database.ChannelTypeGroupDM && len(channel.Members) == 0 {
+		channel.Members = portal.getChannelMembers(userTeam)
+	}
 	if portal.Type == database.ChannelTypeGroupDM && len(channel.Members) == 0 {
 		portal.log.Warnln("Group DM with no members, not bridging")
 		return nil
@@ -366,7 +407,7 @@ func (portal *Portal) CreateMatrixRoom(user *User, userTeam *database.UserTeam,
 	// no members are included in channels, only in group DMs
 	switch portal.Type {
 	case database.ChannelTypeChannel:
-		members = portal.getChannelMembers(userTeam, 3) // TODO: this just fetches 3 members so channels don't have to look like DMs
+		members = portal.getChannelMembers(userTeam)
 	case database.ChannelTypeDM:
 		members = []string{channel.User, userTeam.Key.SlackID}
 	case database.ChannelTypeGroupDM:
@@ -396,6 +437,9 @@ func (portal *Portal) CreateMatrixRoom(user *User, userTeam *database.UserTeam,
 	if !portal.shouldSetDMRoomMetadata() {
 		req.Name = ""
 	}
+	if channel.IsArchived {
+		req.PowerLevelOverride = &event.PowerLevelsEventContent{EventsDefault: archivedEventsDefault}
+	}
 	resp, err := intent.CreateRoom(req)
 	if err != nil {
 		portal.log.Warnln("Failed to create room:", err)
@@ -456,7 +500,7 @@ func (portal *Portal) CreateMatrixRoom(user *User, userTeam *database.UserTeam,
 
 	if portal.bridge.Config.Bridge.Backfill.Enable {
 		portal.log.Debugln("Performing initial backfill batch")
-		initialMessages, err := userTeam.Client.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		initialMessages, err := userTeam.Client().GetConversationHistory(&slack.GetConversationHistoryParameters{
 			ChannelID: portal.Key.ChannelID,
 			Inclusive: true,
 			Limit:     portal.bridge.Config.Bridge.Backfill.ImmediateMessages,
@@ -482,33 +526,105 @@ func (portal *Portal) CreateMatrixRoom(user *User, userTeam *database.UserTeam,
 		portal.bridge.BackfillQueue.ReCheck()
 	}
 
+	portal.SyncSlackPins(userTeam)
+
 	return nil
 }
 
-func (portal *Portal) getChannelMembers(userTeam *database.UserTeam, limit int) []string {
-	members, _, err := userTeam.Client.GetUsersInConversation(&slack.GetUsersInConversationParameters{
-		ChannelID: portal.Key.ChannelID,
-		Limit:     limit,
-	})
+const channelMembersPageSize = 200
+
+func (portal *Portal) getChannelMembers(userTeam *database.UserTeam) []string {
+	var members []string
+	var cursor string
+	for {
+		page, nextCursor, err := userTeam.Client().GetUsersInConversation(&slack.GetUsersInConversationParameters{
+			ChannelID: portal.Key.ChannelID,
+			Cursor:    cursor,
+			Limit:     channelMembersPageSize,
+		})
+		if err != nil {
+			portal.log.Errorfln("Error fetching channel members for %v: %v", portal.Key, err)
+			return members
+		}
+		members = append(members, page...)
+		if nextCursor == "" {
+			return members
+		}
+		cursor = nextCursor
+	}
+}
+
+func (portal *Portal) createPrivatePortalFromInvite(roomID id.RoomID, inviter *User, userTeam *database.UserTeam, puppet *Puppet) {
+	portal.roomCreateLock.Lock()
+	defer portal.roomCreateLock.Unlock()
+
+	intent := puppet.DefaultIntent()
+
+	var existingEncryption event.EncryptionEventContent
+	var encryptionEnabled bool
+	err := intent.StateEvent(roomID, event.StateEncryption, "", &existingEncryption)
 	if err != nil {
-		portal.log.Errorfln("Error fetching channel members for %v: %v", portal.Key, err)
-		return nil
+		portal.log.Warnfln("Failed to check if encryption is enabled in private chat room %s", roomID)
+	} else {
+		encryptionEnabled = existingEncryption.Algorithm == id.AlgorithmMegolmV1
 	}
-	return members
 
+	portal.Type = database.ChannelTypeDM
+	portal.DMUserID = puppet.UserID
+	portal.MXID = roomID
+	portal.Name = puppet.Name
+	portal.Avatar = puppet.Avatar
+	portal.AvatarURL = puppet.AvatarURL
+	portal.bridge.portalsLock.Lock()
+	portal.bridge.portalsByMXID[portal.MXID] = portal
+	portal.bridge.portalsLock.Unlock()
+	portal.log.Infofln("Created private chat portal in %s after invite from %s", roomID, inviter.MXID)
+
+	if portal.bridge.Config.Bridge.Encryption.Default || encryptionEnabled {
+		_, err = intent.InviteUser(roomID, &mautrix.ReqInviteUser{UserID: portal.bridge.Bot.UserID})
+		if err != nil {
+			portal.log.Warnln("Failed to invite bridge bot to enable e2be:", err)
+		}
+		err = portal.bridge.Bot.EnsureJoined(roomID)
+		if err != nil {
+			portal.log.Warnln("Failed to join as bridge bot to enable e2be:", err)
+		}
+		if !encryptionEnabled {
+			_, err = intent.SendStateEvent(roomID, event.StateEncryption, "", portal.GetEncryptionEventContent())
+			if err != nil {
+				portal.log.Warnln("Failed to enable e2be:", err)
+			}
+		}
+		portal.bridge.AS.StateStore.SetMembership(roomID, inviter.MXID, event.MembershipJoin)
+		portal.bridge.AS.StateStore.SetMembership(roomID, puppet.MXID, event.MembershipJoin)
+		portal.bridge.AS.StateStore.SetMembership(roomID, portal.bridge.Bot.UserID, event.MembershipJoin)
+		portal.Encrypted = true
+	}
+
+	if portal.shouldSetDMRoomMetadata() {
+		_, err = intent.SetRoomName(portal.MXID, portal.Name)
+		portal.NameSet = err == nil
+		_, err = intent.SetRoomAvatar(portal.MXID, portal.AvatarURL)
+		portal.AvatarSet = err == nil
+	}
+	portal.Update(nil)
+	portal.InsertUser(userTeam.Key)
+	portal.UpdateBridgeInfo()
+	_, _ = intent.SendNotice(roomID, "Private chat portal created")
 }
 
 func (portal *Portal) ensureUserInvited(user *User) bool {
 	return user.ensureInvited(portal.MainIntent(), portal.MXID, portal.IsPrivateChat())
 }
 
-func (portal *Portal) markMessageHandled(txn dbutil.Transaction, slackID string, slackThreadID string, mxid id.EventID, authorID string) *database.Message {
+func (portal *Portal) markMessageHandled(txn dbutil.Transaction, slackID, slackThreadID, slackEditTs string, mxid id.EventID, authorID string) *database.Message {
 	msg := portal.bridge.DB.Message.New()
 	msg.Channel = portal.Key
 	msg.SlackID = slackID
 	msg.MatrixID = mxid
 	msg.AuthorID = authorID
 	msg.SlackThreadID = slackThreadID
+	msg.EditTimestamp = slackEditTs
 	msg.Insert(txn)
 
 	return msg
@@ -595,6 +711,12 @@ func (portal *Portal) handleMatrixMessages(msg portalMatrixMessage) {
 		portal.handleMatrixRedaction(msg.user, msg.evt)
 	case event.EventReaction:
 		portal.handleMatrixReaction(msg.user, msg.evt, &ms)
+	case event.StatePinnedEvents:
+		portal.handleMatrixPinnedEvents(msg.user, msg.evt)
+	case event.StateRoomName:
+		portal.handleMatrixName(msg.user, msg.evt)
+	case event.StateTopic:
+		portal.handleMatrixTopic(msg.user, msg.evt)
 	default:
 		portal.log.Debugln("unknown event type", msg.evt.Type)
 	}
@@ -607,12 +729,17 @@ func (portal *Portal) handleMatrixMessage(sender *User, evt *event.Event, ms *me
 	start := time.Now()
 
 	userTeam := sender.GetUserTeam(portal.Key.TeamID)
+	isRelay := false
+	if userTeam == nil {
+		userTeam = portal.getRelayUserTeam()
+		isRelay = userTeam != nil
+	}
 	if userTeam == nil {
 		portal.log.Warnfln("User %s not logged into team %s", sender.MXID, portal.Key.TeamID)
 		go ms.sendMessageMetrics(evt, errUserNotLoggedIn, "Ignoring", true)
 		return
 	}
-	if userTeam.Client == nil {
+	if userTeam.Client() == nil {
 		portal.log.Errorfln("Client for userteam %s is nil!", userTeam.Key)
 		return
 	}
@@ -657,7 +784,7 @@ func (portal *Portal) handleMatrixMessage(sender *User, evt *event.Event, ms *me
 	ms.timings.preproc = time.Since(start)
 
 	start = time.Now()
-	options, fileUpload, threadTs, err := portal.convertMatrixMessage(ctx, sender, userTeam, evt)
+	options, fileUpload, threadTs, err := portal.convertMatrixMessage(ctx, sender, userTeam, evt, isRelay)
 	ms.timings.convert = time.Since(start)
 
 	start = time.Now()
@@ -667,9 +794,9 @@ func (portal *Portal) handleMatrixMessage(sender *User, evt *event.Event, ms *me
 		return
 	} else if options != nil {
 		portal.log.Debugfln("Sending message %s to Slack %s %s", evt.ID, portal.Key.TeamID, portal.Key.ChannelID)
-		_, timestamp, err = userTeam.Client.PostMessage(
+		_, timestamp, err = userTeam.Client().PostMessage(
 			portal.Key.ChannelID,
-			slack.MsgOptionAsUser(true),
+			slack.MsgOptionAsUser(!isRelay || !portal.canRelayOverrideSender(userTeam)),
 			slack.MsgOptionCompose(options...))
 		if err != nil {
 			go ms.sendMessageMetrics(evt, err, "Error sending", true)
@@ -677,7 +804,7 @@ func (portal *Portal) handleMatrixMessage(sender *User, evt *event.Event, ms *me
 		}
 	} else if fileUpload != nil {
 		portal.log.Debugfln("Uploading file from message %s to Slack %s %s", evt.ID, portal.Key.TeamID, portal.Key.ChannelID)
-		file, err := userTeam.Client.UploadFile(*fileUpload)
+		file, err := userTeam.Client().UploadFile(*fileUpload)
 		if err != nil {
 			portal.log.Errorfln("Failed to upload slack attachment: %v", err)
 			go ms.sendMessageMetrics(evt, errMediaSlackUploadFailed, "Error uploading", true)
@@ -699,7 +826,15 @@ func (portal *Portal) handleMatrixMessage(sender *User, evt *event.Event, ms *me
 	go ms.sendMessageMetrics(evt, err, "Error sending", true)
 	// TODO: store these timings in some way
 
-	if timestamp != "" {
+	if content, ok := evt.Content.Parsed.(*event.MessageEventContent); ok && content.RelatesTo != nil && content.RelatesTo.Type == event.RelReplace {
+		// Edits reuse the original message ID, so only remember which edit was bridged last
+		if editTarget := portal.bridge.DB.Message.GetByMatrixID(portal.Key, content.RelatesTo.EventID); editTarget != nil && timestamp != "" {
+			editTs := portal.getSlackEditTimestamp(userTeam, editTarget)
+			if editTs != "" {
+				editTarget.SetEditTimestamp(editTs)
+			}
+		}
+	} else if timestamp != "" {
 		dbMsg := portal.bridge.DB.Message.New()
 		dbMsg.Channel = portal.Key
 		dbMsg.SlackID = timestamp
@@ -710,7 +845,57 @@ func (portal *Portal) handleMatrixMessage(sender *User, evt *event.Event, ms *me
 	}
 }
 
-func (portal *Portal) convertMatrixMessage(ctx context.Context, sender *User, userTeam *database.UserTeam, evt *event.Event) (options []slack.MsgOption, fileUpload *slack.FileUploadParameters, threadTs string, err error) {
+// isMatrixEventSentBy checks whether the given Matrix event in the portal room was sent by the given user.
+func (portal *Portal) isMatrixEventSentBy(eventID id.EventID, userID id.UserID) bool {
+	evt, err := portal.MainIntent().GetEvent(portal.MXID, eventID)
+	if err != nil {
+		portal.log.Warnfln("Failed to get event %s to check its sender: %v", eventID, err)
+		return false
+	}
+	return evt.Sender == userID
+}
+
+// getSlackEditTimestamp fetches the timestamp of the latest edit of a message, as chat.update doesn't return it.
+func (portal *Portal) getSlackEditTimestamp(userTeam *database.UserTeam, message *database.Message) string {
+	var messages []slack.Message
+	err := portal.bridge.BackfillQueue.CallWithRateLimit(portal.Key.TeamID, func() (err error) {
+		if message.SlackThreadID != "" && message.SlackThreadID != message.SlackID {
+			messages, _, _, err = userTeam.Client().GetConversationReplies(&slack.GetConversationRepliesParameters{
+				ChannelID: portal.Key.ChannelID,
+				Timestamp: message.SlackThreadID,
+				Latest:    message.SlackID,
+				Oldest:    message.SlackID,
+				Inclusive: true,
+				Limit:     1,
+			})
+		} else {
+			var resp *slack.GetConversationHistoryResponse
+			resp, err = userTeam.Client().GetConversationHistory(&slack.GetConversationHistoryParameters{
+				ChannelID: portal.Key.ChannelID,
+				Latest:    message.SlackID,
+				Oldest:    message.SlackID,
+				Inclusive: true,
+				Limit:     1,
+			})
+			if resp != nil {
+				messages = resp.Messages
+			}
+		}
+		return
+	})
+	if err != nil {
+		portal.log.Warnfln("Failed to fetch edit timestamp of %s: %v", message.SlackID, err)
+		return ""
+	}
+	for _, msg := range messages {
+		if msg.Timestamp == message.SlackID && msg.Edited != nil {
+			return msg.Edited.Timestamp
+		}
+	}
+	return ""
+}
+
+func (portal *Portal) convertMatrixMessage(ctx context.Context, sender *User, userTeam *database.UserTeam, evt *event.Event, isRelay bool) (options []slack.MsgOption, fileUpload *slack.FileUploadParameters, threadTs string, err error) {
 	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
 	if !ok {
 		return nil, nil, "", errUnexpectedParsedContentType
@@ -720,6 +905,10 @@ func (portal *Portal) convertMatrixMessage(ctx context.Context, sender *User, us
 	if content.RelatesTo != nil && content.RelatesTo.Type == event.RelReplace { // fetch the slack original TS for editing purposes
 		existing := portal.bridge.DB.Message.GetByMatrixID(portal.Key, content.RelatesTo.EventID)
 		if existing != nil && existing.SlackID != "" {
+			if isRelay && !portal.isMatrixEventSentBy(existing.MatrixID, sender.MXID) {
+				// Everything relayed is sent as the relay user, so Slack would allow editing any of it
+				return nil, nil, "", errEditSentBySomeoneElse
+			}
 			existingTs = existing.SlackID
 			content = content.NewContent
 		} else {
@@ -752,6 +941,25 @@ func (portal *Portal) convertMatrixMessage(ctx context.Context, sender *User, us
 		}
 	}
 
+	var relayText string
+	isText := content.MsgType == event.MsgText || content.MsgType == event.MsgEmote || content.MsgType == event.MsgNotice
+	relayOverride := isRelay && portal.canRelayOverrideSender(userTeam)
+	if isRelay && !(relayOverride && isText) {
+		relayText, err = portal.bridge.Config.Bridge.Relay.FormatMessage(content, sender.MXID, portal.getRelaySender(sender))
+		if err != nil {
+			portal.log.Errorfln("Failed to format relay message %s: %v", evt.ID, err)
+			return nil, nil, "", err
+		}
+		if isText {
+			content = &event.MessageEventContent{
+				MsgType:       event.MsgText,
+				Body:          content.Body,
+				Format:        event.FormatHTML,
+				FormattedBody: relayText,
+			}
+		}
+	}
+
 	switch content.MsgType {
 	case event.MsgText, event.MsgEmote, event.MsgNotice:
 		if content.Format == event.FormatHTML {
@@ -768,6 +976,17 @@ func (portal *Portal) convertMatrixMessage(ctx context.Context, sender *User, us
 		if content.MsgType == event.MsgEmote {
 			options = append(options, slack.MsgOptionMeMessage())
 		}
+		if relayOverride {
+			member := portal.getRelaySender(sender)
+			username := member.Displayname
+			if username == "" {
+				username = sender.MXID.String()
+			}
+			options = append(options, slack.MsgOptionUsername(username))
+			if avatarURL, err := member.AvatarURL.Parse(); err == nil && !avatarURL.IsEmpty() {
+				options = append(options, slack.MsgOptionIconURL(portal.bridge.Bot.GetDownloadURL(avatarURL)))
+			}
+		}
 		return options, nil, threadTs, nil
 	case event.MsgAudio, event.MsgFile, event.MsgImage, event.MsgVideo:
 		data, err := portal.downloadMatrixAttachment(content)
@@ -782,6 +1001,10 @@ func (portal *Portal) convertMatrixMessage(ctx context.Context, sender *User, us
 			Channels:        []string{portal.Key.ChannelID},
 			ThreadTimestamp: threadTs,
 		}
+		if isRelay {
+			// File uploads can't override the sender, so always include the relay text
+			fileUpload.InitialComment = portal.bridge.ParseMatrix(relayText)
+		}
 		return nil, fileUpload, threadTs, nil
 	default:
 		return nil, nil, "", errUnknownMsgType
@@ -844,6 +1067,9 @@ func (portal *Portal) handleMatrixReaction(sender *User, evt *event.Event, ms *m
 				emojiID = customEmoji.SlackID
 			}
 		}
+	} else if shortcodeMatch := shortcodeReactionRegex.FindStringSubmatch(reaction.RelatesTo.Key); shortcodeMatch != nil {
+		// Custom emoji reactions bridged from Slack without custom_emoji_reactions
+		emojiID = shortcodeMatch[1]
 	} else {
 		emojiID = emojiToShortcode(reaction.RelatesTo.Key)
 	}
@@ -854,21 +1080,7 @@ func (portal *Portal) handleMatrixReaction(sender *User, evt *event.Event, ms *m
 		return
 	}
 
-	// TODO: Figure out if this is a custom emoji or not.
-	// emojiID := reaction.RelatesTo.Key
-	// if strings.HasPrefix(emojiID, "mxc://") {
-	// 	uri, _ := id.ParseContentURI(emojiID)
-	// 	emoji := portal.bridge.DB.Emoji.GetByMatrixURL(uri)
-	// 	if emoji == nil {
-	// 		portal.log.Errorfln("failed to find emoji for %s", emojiID)
-
-	// 		return
-	// 	}
-
-	// 	emojiID = emoji.APIName()
-	// }
-
-	err := userTeam.Client.AddReaction(emojiID, slack.ItemRef{
+	err := userTeam.Client().AddReaction(emojiID, slack.ItemRef{
 		Channel:   portal.Key.ChannelID,
 		Timestamp: slackID,
 	})
@@ -904,7 +1116,7 @@ func (portal *Portal) handleMatrixRedaction(user *User, evt *event.Event) {
 	message := portal.bridge.DB.Message.GetByMatrixID(portal.Key, evt.Redacts)
 	if message != nil {
 		if message.SlackID != "" {
-			_, _, err := userTeam.Client.DeleteMessage(portal.Key.ChannelID, message.SlackID)
+			_, _, err := userTeam.Client().DeleteMessage(portal.Key.ChannelID, message.SlackID)
 			if err != nil {
 				portal.log.Debugfln("Failed to delete slack message %s: %v", message.SlackID, err)
 			} else {
@@ -921,7 +1133,7 @@ func (portal *Portal) handleMatrixRedaction(user *User, evt *event.Event) {
 	reaction := portal.bridge.DB.Reaction.GetByMatrixID(portal.Key, evt.Redacts)
 	if reaction != nil {
 		if reaction.SlackName != "" {
-			err := userTeam.Client.RemoveReaction(reaction.SlackName, slack.ItemRef{
+			err := userTeam.Client().RemoveReaction(reaction.SlackName, slack.ItemRef{
 				Channel:   portal.Key.ChannelID,
 				Timestamp: reaction.SlackMessageID,
 			})
@@ -1006,13 +1218,261 @@ func (portal *Portal) sendSlackRepeatTyping() {
 }
 
 func (portal *Portal) HandleMatrixLeave(brSender bridge.User) {
-	portal.log.Debugln("User left private chat portal, cleaning up and deleting...")
-	portal.delete()
-	portal.cleanup(false)
-
+	// Leaves were never passed to the portal before invites and kicks were bridged, and deleting private
+	// chat portals on leave would delete the whole room on some servers, so leaving is still not bridged.
 	// TODO: figure out how to close a dm from the API.
+	portal.log.Debugfln("%s left the portal, ignoring", brSender.GetMXID())
+}
 
-	portal.cleanupIfEmpty()
+// sendBridgeNotice sends a notice from the bridge to the portal room.
+func (portal *Portal) sendBridgeNotice(format string, args ...interface{}) {
+	content := &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    fmt.Sprintf(format, args...),
+	}
+	_, err := portal.sendMatrixMessage(portal.MainIntent(), event.EventMessage, content, nil, 0)
+	if err != nil {
+		portal.log.Warnln("Failed to send notice:", err)
+	}
+}
+
+// getMembershipUserTeam returns the user team that Matrix membership changes of a ghost should be
+// sent to Slack through, or nil after telling the sender why the change can't be bridged.
+func (portal *Portal) getMembershipUserTeam(sender *User, puppet *Puppet, action string) *database.UserTeam {
+	if portal.Type != database.ChannelTypeChannel {
+		portal.sendBridgeNotice("Can't %s %s: members can only be changed in channels", action, puppet.Name)
+		return nil
+	} else if puppet.TeamID != portal.Key.TeamID {
+		portal.sendBridgeNotice("Can't %s %s: the user is in a different Slack team", action, puppet.Name)
+		return nil
+	}
+	userTeam := sender.GetUserTeam(portal.Key.TeamID)
+	if userTeam == nil || userTeam.Client() == nil {
+		portal.sendBridgeNotice("Can't %s %s: you're not logged into the Slack team of this room", action, puppet.Name)
+		return nil
+	}
+	return userTeam
+}
+
+func (portal *Portal) HandleMatrixInvite(brSender bridge.User, brGhost bridge.Ghost) {
+	sender := brSender.(*User)
+	puppet := brGhost.(*Puppet)
+	userTeam := portal.getMembershipUserTeam(sender, puppet, "invite")
+	if userTeam == nil {
+		_, _ = puppet.DefaultIntent().LeaveRoom(portal.MXID)
+		return
+	}
+
+	portal.log.Debugfln("%s invited %s, inviting them to the channel", sender.MXID, puppet.UserID)
+	_, err := userTeam.Client().InviteUsersToConversation(portal.Key.ChannelID, puppet.UserID)
+	if err != nil && err.Error() != "already_in_channel" {
+		portal.log.Warnfln("Failed to invite %s to the channel: %v", puppet.UserID, err)
+		portal.sendBridgeNotice("Failed to invite %s on Slack: %v", puppet.Name, err)
+		// Reject the invite so the Matrix room doesn't claim the user is being added
+		_, _ = puppet.DefaultIntent().LeaveRoom(portal.MXID)
+		return
+	}
+	portal.syncParticipants(sender, userTeam, []string{puppet.UserID}, true)
+}
+
+// readdKickedGhost brings a ghost back into the room after a kick or ban that couldn't be bridged,
+// so that the Matrix room doesn't claim the user was removed from the channel.
+func (portal *Portal) readdKickedGhost(puppet *Puppet) {
+	if member := portal.MainIntent().Member(portal.MXID, puppet.MXID); member != nil && member.Membership == event.MembershipBan {
+		_, err := portal.MainIntent().UnbanUser(portal.MXID, &mautrix.ReqUnbanUser{UserID: puppet.MXID})
+		if err != nil {
+			portal.log.Warnfln("Failed to unban %s after failed removal: %v", puppet.MXID, err)
+			return
+		}
+	}
+	err := puppet.DefaultIntent().EnsureJoined(portal.MXID)
+	if err != nil {
+		portal.log.Warnfln("Failed to re-add %s after failed removal: %v", puppet.MXID, err)
+	}
+}
+
+func (portal *Portal) HandleMatrixKick(brSender bridge.User, brGhost bridge.Ghost) {
+	sender := brSender.(*User)
+	puppet := brGhost.(*Puppet)
+	userTeam := portal.getMembershipUserTeam(sender, puppet, "remove")
+	if userTeam == nil {
+		portal.readdKickedGhost(puppet)
+		return
+	}
+
+	portal.log.Debugfln("%s removed %s, removing them from the channel", sender.MXID, puppet.UserID)
+	err := userTeam.Client().KickUserFromConversation(portal.Key.ChannelID, puppet.UserID)
+	if err != nil && err.Error() != "not_in_channel" {
+		portal.log.Warnfln("Failed to remove %s from the channel: %v", puppet.UserID, err)
+		portal.sendBridgeNotice("Failed to remove %s on Slack: %v", puppet.Name, err)
+		portal.readdKickedGhost(puppet)
+	}
+}
+
+func (portal *Portal) HandleMatrixMeta(brSender bridge.User, evt *event.Event) {
+	switch evt.Type {
+	case event.StateRoomName, event.StateTopic:
+		portal.matrixMessages <- portalMatrixMessage{user: brSender.(*User), evt: evt, receivedAt: time.Now()}
+	default:
+		portal.log.Debugfln("Ignoring %s change %s from %s", evt.Type.Type, evt.ID, evt.Sender)
+	}
+}
+
+// getMetaUserTeam returns the user team that Matrix metadata changes should be sent to Slack through.
+// Only channels can be renamed, and relaying isn't used as the change would be attributed to the relay user.
+func (portal *Portal) getMetaUserTeam(sender *User, evt *event.Event) *database.UserTeam {
+	if portal.Type != database.ChannelTypeChannel {
+		portal.log.Debugfln("Ignoring %s change %s in non-channel portal", evt.Type.Type, evt.ID)
+		return nil
+	}
+	userTeam := sender.GetUserTeam(portal.Key.TeamID)
+	if userTeam == nil || userTeam.Client() == nil {
+		go portal.sendMessageMetrics(evt, errUserNotLoggedIn, "Ignoring", nil)
+		return nil
+	}
+	return userTeam
+}
+
+// maxSlackChannelNameLength is the maximum length of Slack channel names in characters.
+const maxSlackChannelNameLength = 80
+
+// slackChannelName converts a Matrix room name into a valid Slack channel name. Slack only allows
+// lowercase letters, numbers, hyphens and underscores, so other characters are replaced with hyphens.
+func slackChannelName(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	var out []rune
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			out = append(out, r)
+		} else if len(out) > 0 && out[len(out)-1] != '-' {
+			out = append(out, '-')
+		}
+	}
+	if len(out) > maxSlackChannelNameLength {
+		out = out[:maxSlackChannelNameLength]
+	}
+	return strings.Trim(string(out), "-")
+}
+
+func (portal *Portal) handleMatrixName(sender *User, evt *event.Event) {
+	userTeam := portal.getMetaUserTeam(sender, evt)
+	if userTeam == nil {
+		return
+	}
+	content, ok := evt.Content.Parsed.(*event.RoomNameEventContent)
+	if !ok {
+		go portal.sendMessageMetrics(evt, errUnexpectedParsedContentType, "Ignoring", nil)
+		return
+	}
+	name := slackChannelName(content.Name)
+	if content.Name == portal.Name || name == portal.PlainName || name == "" {
+		portal.log.Debugfln("Ignoring name change %s from %s: channel name is already %q", evt.ID, evt.Sender, portal.PlainName)
+		return
+	}
+
+	portal.log.Debugfln("Renaming channel to %q as requested by %s in %s", name, evt.Sender, evt.ID)
+	meta, err := userTeam.Client().RenameConversation(portal.Key.ChannelID, name)
+	if err != nil {
+		go portal.sendMessageMetrics(evt, fmt.Errorf("failed to rename channel: %w", err), "Error sending", nil)
+		// Put the Slack name back, so the room doesn't claim a name the channel doesn't have
+		_, err = portal.MainIntent().SetRoomName(portal.MXID, portal.Name)
+		if err != nil {
+			portal.log.Warnln("Failed to revert room name after failed rename:", err)
+		}
+		return
+	}
+	// The room already has the new name, so only change it again if Slack normalized the name differently.
+	// This also makes the channel_name message that Slack sends back a no-op.
+	portal.Name = content.Name
+	portal.NameSet = true
+	portal.UpdateInfo(sender, userTeam, meta, true)
+	go portal.sendMessageMetrics(evt, nil, "", nil)
+}
+
+// parseMatrixTopic splits a room topic into the Slack topic and purpose, reversing getTopic.
+// Topics that weren't formatted by the bridge only change the Slack topic.
+func parseMatrixTopic(topic string) (slackTopic, slackPurpose string, hasPurpose bool) {
+	const topicPrefix = "Topic: "
+	const purposePrefix = "Description: "
+	if !strings.HasPrefix(topic, topicPrefix) && !strings.HasPrefix(topic, purposePrefix) {
+		return topic, "", false
+	}
+	var topicLines, purposeLines []string
+	inPurpose := false
+	for _, line := range strings.Split(topic, "\n") {
+		if strings.HasPrefix(line, topicPrefix) && !inPurpose {
+			topicLines = append(topicLines, strings.TrimPrefix(line, topicPrefix))
+		} else if strings.HasPrefix(line, purposePrefix) && !inPurpose {
+			inPurpose = true
+			purposeLines = append(purposeLines, strings.TrimPrefix(line, purposePrefix))
+		} else if inPurpose {
+			purposeLines = append(purposeLines, line)
+		} else {
+			topicLines = append(topicLines, line)
+		}
+	}
+	return strings.Join(topicLines, "\n"), strings.Join(purposeLines, "\n"), true
+}
+
+func (portal *Portal) handleMatrixTopic(sender *User, evt *event.Event) {
+	userTeam := portal.getMetaUserTeam(sender, evt)
+	if userTeam == nil {
+		return
+	}
+	content, ok := evt.Content.Parsed.(*event.TopicEventContent)
+	if !ok {
+		go portal.sendMessageMetrics(evt, errUnexpectedParsedContentType, "Ignoring", nil)
+		return
+	} else if content.Topic == portal.Topic {
+		portal.log.Debugfln("Ignoring topic change %s from %s: topic didn't change", evt.ID, evt.Sender)
+		return
+	}
+
+	oldTopic, oldPurpose, _ := parseMatrixTopic(portal.Topic)
+	newTopic, newPurpose, hasPurpose := parseMatrixTopic(content.Topic)
+	var meta *slack.Channel
+	var err error
+	if newTopic != oldTopic {
+		portal.log.Debugfln("Changing channel topic as requested by %s in %s", evt.Sender, evt.ID)
+		meta, err = userTeam.Client().SetTopicOfConversation(portal.Key.ChannelID, newTopic)
+		if err != nil {
+			go portal.sendMessageMetrics(evt, fmt.Errorf("failed to change channel topic: %w", err), "Error sending", nil)
+			portal.revertMatrixTopic(sender, userTeam, nil)
+			return
+		}
+	}
+	if hasPurpose && newPurpose != oldPurpose {
+		portal.log.Debugfln("Changing channel description as requested by %s in %s", evt.Sender, evt.ID)
+		purposeMeta, err := userTeam.Client().SetPurposeOfConversation(portal.Key.ChannelID, newPurpose)
+		if err != nil {
+			go portal.sendMessageMetrics(evt, fmt.Errorf("failed to change channel description: %w", err), "Error sending", nil)
+			portal.revertMatrixTopic(sender, userTeam, meta)
+			return
+		}
+		meta = purposeMeta
+	}
+	// Like with names, only update the room topic if Slack's version differs from what the user set.
+	portal.Topic = content.Topic
+	portal.TopicSet = true
+	if meta != nil {
+		portal.UpdateInfo(sender, userTeam, meta, true)
+	} else {
+		portal.Update(nil)
+	}
+	go portal.sendMessageMetrics(evt, nil, "", nil)
+}
+
+// revertMatrixTopic sets the room topic back to match Slack after a topic change failed. If the Slack topic
+// was changed before the description failed, meta is the channel info after that change.
+func (portal *Portal) revertMatrixTopic(sender *User, userTeam *database.UserTeam, meta *slack.Channel) {
+	if meta != nil {
+		portal.UpdateInfo(sender, userTeam, meta, true)
+		return
+	}
+	_, err := portal.MainIntent().SetRoomTopic(portal.MXID, portal.Topic)
+	if err != nil {
+		portal.log.Warnln("Failed to revert room topic after failed change:", err)
+	}
 }
 
 func (portal *Portal) leave(userTeam *database.UserTeam) {
@@ -1253,8 +1713,10 @@ func (portal *Portal) UpdateNameDirect(name string) bool {
 }
 
 func (portal *Portal) UpdateName(meta *slack.Channel, sourceTeam *database.UserTeam) bool {
-	plainName := portal.GetPlainName(meta)
+	return portal.updatePlainName(portal.GetPlainName(meta), sourceTeam)
+}
 
+func (portal *Portal) updatePlainName(plainName string, sourceTeam *database.UserTeam) bool {
 	plainNameChanged := portal.PlainName != plainName
 	portal.PlainName = plainName
 
@@ -1313,13 +1775,13 @@ func (portal *Portal) UpdateTopicDirect(topic string) bool {
 	return true
 }
 
-func (portal *Portal) getTopic(meta *slack.Channel) string {
+func (portal *Portal) getTopic(meta *slack.Channel, sourceTeam *database.UserTeam) string {
 	switch portal.Type {
 	case database.ChannelTypeDM, database.ChannelTypeGroupDM:
 		return ""
 	case database.ChannelTypeChannel:
-		plainTopic := meta.Topic.Value
-		plainDescription := meta.Purpose.Value
+		plainTopic := portal.bridge.ReplaceEmojiShortcodes(meta.Topic.Value, sourceTeam)
+		plainDescription := portal.bridge.ReplaceEmojiShortcodes(meta.Purpose.Value, sourceTeam)
 
 		var topicParts []string
 
@@ -1337,7 +1799,7 @@ func (portal *Portal) getTopic(meta *slack.Channel) string {
 }
 
 func (portal *Portal) UpdateTopic(meta *slack.Channel, sourceTeam *database.UserTeam) bool {
-	matrixTopic := portal.getTopic(meta)
+	matrixTopic := portal.getTopic(meta, sourceTeam)
 
 	changed := portal.Topic != matrixTopic
 	return portal.UpdateTopicDirect(matrixTopic) || changed
@@ -1350,7 +1812,7 @@ func (portal *Portal) UpdateInfo(source *User, sourceTeam *database.UserTeam, me
 	if meta == nil {
 		portal.log.Debugfln("UpdateInfo called without metadata, fetching from server via %s", sourceTeam.Key.SlackID)
 		var err error
-		meta, err = sourceTeam.Client.GetConversationInfo(&slack.GetConversationInfoInput{
+		meta, err = sourceTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{
 			ChannelID:         portal.Key.ChannelID,
 			IncludeLocale:     true,
 			IncludeNumMembers: true,
@@ -1371,6 +1833,9 @@ func (portal *Portal) UpdateInfo(source *User, sourceTeam *database.UserTeam, me
 
 	changed = portal.UpdateName(meta, sourceTeam) || changed
 	changed = portal.UpdateTopic(meta, sourceTeam) || changed
+	if portal.MXID != "" {
+		portal.updateArchivedPowerLevels(meta.IsArchived)
+	}
 
 	if changed || force {
 		portal.UpdateBridgeInfo()
@@ -1390,9 +1855,19 @@ type ConvertedSlackMessage struct {
 	Event           *event.MessageEventContent
 	SlackTimestamp  string
 	SlackThreadTs   string
+	SlackEditTs     string
 	SlackAuthor     string
 	SlackReactions  []slack.ItemReaction
 	SlackThread     []slack.Message
+	// BatchReactions are the reactions that were included in a backfill batch along with the message.
+	BatchReactions []BatchSlackReaction
+}
+
+type BatchSlackReaction struct {
+	SlackAuthor string
+	SlackName   string
+	MatrixName  string
+	MatrixURL   string
 }
 
 func (portal *Portal) HandleSlackMessage(user *User, userTeam *database.UserTeam, msg *slack.MessageEvent) {
@@ -1417,6 +1892,11 @@ func (portal *Portal) HandleSlackMessage(user *User, userTeam *database.UserTeam
 		portal.log.Debugfln("Not sending edit for nonexistent message %s", msg.Msg.Timestamp)
 		return
 	}
+	if msg.Msg.SubType == "message_changed" && msg.SubMessage != nil && msg.SubMessage.Edited != nil &&
+		existing.EditTimestamp == msg.SubMessage.Edited.Timestamp {
+		portal.log.Debugfln("Dropping duplicate edit %s of %s", msg.SubMessage.Edited.Timestamp, msg.Msg.Timestamp)
+		return
+	}
 
 	if msg.Msg.User == "" {
 		portal.log.Debugfln("Starting handling of %s (no sender), subtype %s", msg.Msg.Timestamp, msg.Msg.SubType)
@@ -1433,29 +1913,12 @@ func (portal *Portal) HandleSlackMessage(user *User, userTeam *database.UserTeam
 		portal.UpdateInfo(user, userTeam, nil, false)
 		portal.log.Debugfln("Received %s update, updating portal name and topic", msg.Msg.SubType)
 	case "message_deleted":
-		// Slack doesn't tell us who deleted a message, so there is no intent here
-		message := portal.bridge.DB.Message.GetBySlackID(portal.Key, msg.Msg.DeletedTimestamp)
-		if message == nil {
-			portal.log.Warnfln("Failed to redact %s: Matrix event not known", msg.Msg.DeletedTimestamp)
-		} else {
-			_, err := portal.MainIntent().RedactEvent(portal.MXID, message.MatrixID)
-			if err != nil {
-				portal.log.Errorfln("Failed to redact %s: %v", message.MatrixID, err)
-			} else {
-				message.Delete()
-			}
-		}
-
-		attachments := portal.bridge.DB.Attachment.GetAllBySlackMessageID(portal.Key, msg.Msg.DeletedTimestamp)
-		for _, attachment := range attachments {
-			_, err := portal.MainIntent().RedactEvent(portal.MXID, attachment.MatrixEventID)
-			if err != nil {
-				portal.log.Errorfln("Failed to redact %s: %v", attachment.MatrixEventID, err)
-			} else {
-				attachment.Delete()
-			}
-		}
-	case "message_replied", "group_join", "group_leave", "channel_join", "channel_leave": // Not yet an exhaustive list.
+		portal.redactSlackMessage(msg.Msg.DeletedTimestamp)
+	case "channel_join", "group_join":
+		portal.HandleSlackMemberJoined(user, userTeam, msg.Msg.User)
+	case "channel_leave", "group_leave":
+		portal.HandleSlackMemberLeft(user, userTeam, msg.Msg.User)
+	case "message_replied", "pinned_item", "unpinned_item", "channel_archive", "channel_unarchive", "group_archive", "group_unarchive": // Not yet an exhaustive list.
 		// These subtypes are simply ignored, because they're handled elsewhere/in other ways (Slack sends multiple info of these events)
 		portal.log.Debugfln("Received message subtype %s, which is ignored", msg.Msg.SubType)
 	default:
@@ -1463,6 +1926,32 @@ func (portal *Portal) HandleSlackMessage(user *User, userTeam *database.UserTeam
 	}
 }
 
+// redactSlackMessage redacts all Matrix events bridged from the given Slack message.
+func (portal *Portal) redactSlackMessage(slackID string) {
+	// Slack doesn't tell us who deleted a message, so there is no intent here
+	message := portal.bridge.DB.Message.GetBySlackID(portal.Key, slackID)
+	if message == nil {
+		portal.log.Warnfln("Failed to redact %s: Matrix event not known", slackID)
+	} else {
+		_, err := portal.MainIntent().RedactEvent(portal.MXID, message.MatrixID)
+		if err != nil {
+			portal.log.Errorfln("Failed to redact %s: %v", message.MatrixID, err)
+		} else {
+			message.Delete()
+		}
+	}
+
+	attachments := portal.bridge.DB.Attachment.GetAllBySlackMessageID(portal.Key, slackID)
+	for _, attachment := range attachments {
+		_, err := portal.MainIntent().RedactEvent(portal.MXID, attachment.MatrixEventID)
+		if err != nil {
+			portal.log.Errorfln("Failed to redact %s: %v", attachment.MatrixEventID, err)
+		} else {
+			attachment.Delete()
+		}
+	}
+}
+
 func (portal *Portal) addThreadMetadata(content *event.MessageEventContent, threadTs string) (hasThread bool, hasReply bool) {
 	// fetch thread metadata and add to message
 	if threadTs != "" {
@@ -1500,36 +1989,22 @@ func (portal *Portal) ConvertSlackMessage(userTeam *database.UserTeam, msg *slac
 		return
 	}
 	converted.SlackTimestamp = msg.Timestamp
-	var text string
-	if msg.Text != "" {
-		text = msg.Text
-	}
-	for _, attachment := range msg.Attachments {
-		if text != "" {
-			text += "\n"
-		}
-		if attachment.Text != "" {
-			text += attachment.Text
-		} else if attachment.Fallback != "" {
-			text += attachment.Fallback
-		}
-	}
 
 	if len(msg.Blocks.BlockSet) != 0 || len(msg.Attachments) != 0 {
 		var err error
-		converted.Event, err = portal.SlackBlocksToMatrix(msg.Blocks, msg.Attachments, userTeam)
+		converted.Event, err = portal.SlackBlocksToMatrix(msg.Text, msg.Blocks, msg.Attachments, userTeam)
 		if err != nil {
 			portal.log.Warnfln("Error rendering Slack blocks: %v", err)
 			converted.Event = nil
 		}
-	} else if text != "" {
-		converted.Event = portal.renderSlackMarkdown(text)
+	} else if msg.Text != "" {
+		converted.Event = portal.renderSlackMarkdown(msg.Text, userTeam)
 	}
 
 	for _, file := range msg.Files {
 		fileInfo := file
 		if file.FileAccess == "check_file_info" {
-			connectFile, _, _, err := userTeam.Client.GetFileInfo(file.ID, 0, 0)
+			connectFile, _, _, err := userTeam.Client().GetFileInfo(file.ID, 0, 0)
 			if err != nil || connectFile == nil {
 				portal.log.Errorln("Error fetching slack connect file info", err)
 				continue
@@ -1556,12 +2031,12 @@ func (portal *Portal) ConvertSlackMessage(userTeam *database.UserTeam, msg *slac
 		portal.log.Debugfln("File download URLs: urlPrivate=%s, urlPrivateDownload=%s", fileInfo.URLPrivate, fileInfo.URLPrivateDownload)
 		if url != "" {
 			portal.log.Debugfln("Downloading private file from Slack: %s", url)
-			err = userTeam.Client.GetFile(url, &data)
+			err = userTeam.Client().GetFile(url, &data)
 			if bytes.HasPrefix(data.Bytes(), []byte("<!DOCTYPE html>")) {
 				portal.log.Warnfln("Received HTML file from Slack (URL %s), trying again in 5 seconds", url)
 				time.Sleep(5 * time.Second)
 				data.Reset()
-				err = userTeam.Client.GetFile(fileInfo.URLPrivate, &data)
+				err = userTeam.Client().GetFile(fileInfo.URLPrivate, &data)
 			} else {
 				portal.log.Debugfln("Download success, expectedSize=%d, downloadedSize=%d", fileInfo.Size, data.Len())
 			}
@@ -1601,6 +2076,9 @@ func (portal *Portal) ConvertSlackMessage(userTeam *database.UserTeam, msg *slac
 	}
 
 	converted.SlackThreadTs = msg.ThreadTimestamp
+	if msg.Edited != nil {
+		converted.SlackEditTs = msg.Edited.Timestamp
+	}
 
 	return converted
 }
@@ -1618,9 +2096,11 @@ func (portal *Portal) HandleSlackNormalMessage(user *User, userTeam *database.Us
 	intent := puppet.IntentFor(portal)
 
 	for _, file := range e.FileAttachments {
-		if editExisting == nil {
-			portal.addThreadMetadata(file.Event, msg.ThreadTimestamp)
+		if editExisting != nil {
+			// Files can't be edited on Slack, so they were already bridged with the original message
+			break
 		}
+		portal.addThreadMetadata(file.Event, msg.ThreadTimestamp)
 
 		resp, err := portal.sendMatrixMessage(intent, event.EventMessage, file.Event, nil, ts.UnixMilli())
 		if err != nil {
@@ -1655,7 +2135,14 @@ func (portal *Portal) HandleSlackNormalMessage(user *User, userTeam *database.Us
 			return
 		}
 
-		portal.markMessageHandled(nil, msg.Timestamp, msg.ThreadTimestamp, resp.EventID, e.SlackAuthor)
+		if editExisting != nil {
+			if e.SlackEditTs != "" {
+				editExisting.SetEditTimestamp(e.SlackEditTs)
+			}
+			return
+		}
+
+		portal.markMessageHandled(nil, msg.Timestamp, msg.ThreadTimestamp, e.SlackEditTs, resp.EventID, e.SlackAuthor)
 		go portal.sendDeliveryReceipt(resp.EventID)
 		return
 	}
@@ -1696,31 +2183,9 @@ func (portal *Portal) HandleSlackReaction(user *User, userTeam *database.UserTea
 		return
 	}
 
-	slackReaction := strings.Trim(msg.Reaction, ":")
-
-	key := portal.bridge.GetEmoji(slackReaction, userTeam)
+	content, customURL := portal.convertSlackReactionToMatrix(targetMessage.MatrixID, msg.Reaction, userTeam)
 
-	var content event.ReactionEventContent
-	content.RelatesTo = event.RelatesTo{
-		Type:    event.RelAnnotation,
-		EventID: targetMessage.MatrixID,
-		Key:     key,
-	}
-	extraContent := map[string]any{}
-	if strings.HasPrefix(key, "mxc://") {
-		extraContent["fi.mau.slack.reaction"] = map[string]any{
-			"name": slackReaction,
-			"mxc":  key,
-		}
-		if !portal.bridge.Config.Bridge.CustomEmojiReactions {
-			content.RelatesTo.Key = slackReaction
-		}
-	}
-
-	resp, err := intent.SendMassagedMessageEvent(portal.MXID, event.EventReaction, &event.Content{
-		Parsed: &content,
-		Raw:    extraContent,
-	}, parseSlackTimestamp(msg.EventTimestamp).UnixMilli())
+	resp, err := intent.SendMassagedMessageEvent(portal.MXID, event.EventReaction, content, parseSlackTimestamp(msg.EventTimestamp).UnixMilli())
 	if err != nil {
 		portal.log.Errorfln("Failed to bridge reaction: %v", err)
 		return
@@ -1731,11 +2196,43 @@ func (portal *Portal) HandleSlackReaction(user *User, userTeam *database.UserTea
 	dbReaction.SlackMessageID = msg.Item.Timestamp
 	dbReaction.MatrixEventID = resp.EventID
 	dbReaction.AuthorID = msg.User
-	dbReaction.MatrixName = key
+	dbReaction.MatrixName = content.AsReaction().RelatesTo.Key
+	dbReaction.MatrixURL = customURL
 	dbReaction.SlackName = msg.Reaction
 	dbReaction.Insert(nil)
 }
 
+// convertSlackReactionToMatrix creates the Matrix reaction event content for a Slack reaction.
+// Custom emoji are sent as mxc:// URIs with the shortcode as a label, or as just the shortcode
+// if custom_emoji_reactions is disabled. The returned URL is empty for Unicode emoji.
+func (portal *Portal) convertSlackReactionToMatrix(targetEventID id.EventID, reaction string, userTeam *database.UserTeam) (*event.Content, string) {
+	slackReaction := strings.Trim(reaction, ":")
+	key := portal.bridge.GetEmoji(slackReaction, userTeam)
+
+	content := &event.ReactionEventContent{
+		RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: targetEventID,
+			Key:     key,
+		},
+	}
+	extraContent := map[string]any{}
+	var customURL string
+	if strings.HasPrefix(key, "mxc://") {
+		customURL = key
+		shortcode := fmt.Sprintf(":%s:", slackReaction)
+		extraContent["fi.mau.slack.reaction"] = map[string]any{
+			"name": slackReaction,
+			"mxc":  key,
+		}
+		extraContent["com.beeper.reaction.shortcode"] = shortcode
+		if !portal.bridge.Config.Bridge.CustomEmojiReactions {
+			content.RelatesTo.Key = shortcode
+		}
+	}
+	return &event.Content{Parsed: content, Raw: extraContent}, customURL
+}
+
 func (portal *Portal) HandleSlackReactionRemoved(user *User, userTeam *database.UserTeam, msg *slack.ReactionRemovedEvent) {
 	if portal.MXID == "" {
 		return
@@ -1753,10 +2250,13 @@ func (portal *Portal) HandleSlackReactionRemoved(user *User, userTeam *database.
 		portal.log.Errorfln("Failed to redact reaction %v %s %s %s: reaction not found in database", portal.Key, msg.User, msg.Item.Timestamp, msg.Reaction)
 		return
 	}
+	portal.redactSlackReaction(userTeam, dbReaction)
+}
 
-	puppet := portal.bridge.GetPuppetByID(portal.Key.TeamID, msg.User)
+func (portal *Portal) redactSlackReaction(userTeam *database.UserTeam, dbReaction *database.Reaction) {
+	puppet := portal.bridge.GetPuppetByID(portal.Key.TeamID, dbReaction.AuthorID)
 	if puppet == nil {
-		portal.log.Errorfln("Not redacting reaction: can't find puppet for Slack user %s %s", portal.Key.TeamID, msg.User)
+		portal.log.Errorfln("Not redacting reaction: can't find puppet for Slack user %s %s", portal.Key.TeamID, dbReaction.AuthorID)
 		return
 	}
 	puppet.UpdateInfo(userTeam, true, nil)
@@ -1764,13 +2264,115 @@ func (portal *Portal) HandleSlackReactionRemoved(user *User, userTeam *database.
 
 	_, err := intent.RedactEvent(portal.MXID, dbReaction.MatrixEventID)
 	if err != nil {
-		portal.log.Errorfln("Failed to redact reaction %v %s %s %s: %v", portal.Key, msg.User, msg.Item.Timestamp, msg.Reaction, err)
+		portal.log.Errorfln("Failed to redact reaction %v %s %s %s: %v", portal.Key, dbReaction.AuthorID, dbReaction.SlackMessageID, dbReaction.SlackName, err)
 		return
 	}
 
 	dbReaction.Delete()
 }
 
+func (portal *Portal) HandleSlackMemberJoined(user *User, userTeam *database.UserTeam, slackUserID string) {
+	if portal.MXID == "" || slackUserID == "" {
+		return
+	}
+	portal.log.Debugfln("Slack user %s joined %s", slackUserID, portal.Key)
+	portal.syncParticipants(user, userTeam, []string{slackUserID}, true)
+}
+
+func (portal *Portal) HandleSlackMemberLeft(user *User, userTeam *database.UserTeam, slackUserID string) {
+	if portal.MXID == "" || slackUserID == "" {
+		return
+	}
+	// Logged in users get their own channel_left event, which is handled by Portal.leave
+	if portal.bridge.GetUserByID(portal.Key.TeamID, slackUserID) != nil {
+		return
+	}
+	puppet := portal.bridge.GetPuppetByID(portal.Key.TeamID, slackUserID)
+	if !portal.bridge.StateStore.IsInRoom(portal.MXID, puppet.MXID) {
+		return
+	}
+	portal.log.Debugfln("Slack user %s left %s", slackUserID, portal.Key)
+	_, err := puppet.DefaultIntent().LeaveRoom(portal.MXID)
+	if err != nil {
+		portal.log.Warnfln("Failed to make puppet of %s leave %s: %v", slackUserID, portal.MXID, err)
+	}
+}
+
+func (portal *Portal) HandleSlackChannelRename(userTeam *database.UserTeam, name string) {
+	if portal.MXID == "" || portal.Type != database.ChannelTypeChannel {
+		return
+	}
+	portal.log.Debugfln("Channel renamed to %q", name)
+	if portal.updatePlainName(name, userTeam) {
+		portal.UpdateBridgeInfo()
+		portal.Update(nil)
+	}
+}
+
+const archivedEventsDefault = 100
+
+func (portal *Portal) HandleSlackChannelArchive(archived bool) {
+	if portal.MXID == "" || !portal.updateArchivedPowerLevels(archived) {
+		return
+	}
+	notice := "This channel was unarchived on Slack."
+	if archived {
+		notice = "This channel was archived on Slack and is now read-only."
+	}
+	_, err := portal.sendMatrixMessage(portal.MainIntent(), event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    notice,
+	}, nil, 0)
+	if err != nil {
+		portal.log.Warnln("Failed to send archive notice:", err)
+	}
+}
+
+// updateArchivedPowerLevels makes the room read-only if the channel is archived, or undoes that if it isn't.
+// It returns true if the power levels were changed.
+func (portal *Portal) updateArchivedPowerLevels(archived bool) bool {
+	intent := portal.MainIntent()
+	levels, err := intent.PowerLevels(portal.MXID)
+	if err != nil {
+		portal.log.Warnln("Failed to get power levels:", err)
+		return false
+	}
+	eventsDefault := 0
+	if archived {
+		eventsDefault = archivedEventsDefault
+	} else if levels.EventsDefault != archivedEventsDefault {
+		// Don't touch levels that weren't set by the bridge
+		return false
+	}
+	if levels.EventsDefault == eventsDefault {
+		return false
+	}
+	portal.log.Debugfln("Channel archived: %t, setting events_default to %d", archived, eventsDefault)
+	levels.EventsDefault = eventsDefault
+	_, err = intent.SetPowerLevels(portal.MXID, levels)
+	if err != nil {
+		portal.log.Warnln("Failed to update power levels:", err)
+		return false
+	}
+	return true
+}
+
+func (portal *Portal) HandleSlackChannelDeleted() {
+	if portal.MXID == "" {
+		portal.delete()
+		return
+	}
+	portal.log.Infoln("Channel was deleted on Slack, cleaning up portal")
+	_, err := portal.MainIntent().SendStateEvent(portal.MXID, event.StateTombstone, "", &event.TombstoneEventContent{
+		Body: "This channel was deleted on Slack.",
+	})
+	if err != nil {
+		portal.log.Warnln("Failed to send tombstone event:", err)
+	}
+	portal.cleanup(false)
+	portal.delete()
+}
+
 func (portal *Portal) HandleSlackTyping(user *User, userTeam *database.UserTeam, msg *slack.UserTypingEvent) {
 	if portal.MXID == "" {
 		return
@@ -0,0 +1,235 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2022 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"maunium.net/go/mautrix/bridge/bridgeconfig"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/database"
+)
+
+const (
+	slackPresenceActive = "active"
+	slackPresenceAuto   = "auto"
+	slackPresenceAway   = "away"
+)
+
+// UpdatePresence sets the presence of the puppet's ghost from a Slack presence ("active" or "away").
+// Slack doesn't tell idle and disconnected users apart, so away users are shown as offline.
+func (puppet *Puppet) UpdatePresence(slackPresence string) {
+	if !puppet.EnablePresence {
+		return
+	}
+	presence := event.PresenceOffline
+	if slackPresence == slackPresenceActive {
+		presence = event.PresenceOnline
+	}
+
+	puppet.presenceLock.Lock()
+	defer puppet.presenceLock.Unlock()
+	puppet.presence = presence
+	puppet.sendPresence()
+}
+
+// presenceRefreshInterval is how often the presence of online ghosts is sent again. Synapse marks users
+// as idle if their presence isn't bumped for 5 minutes, but Slack only sends presence changes.
+const presenceRefreshInterval = 4 * time.Minute
+
+func (br *SlackBridge) refreshPresenceLoop() {
+	for range time.Tick(presenceRefreshInterval) {
+		br.puppetsLock.Lock()
+		puppets := make([]*Puppet, 0, len(br.puppets))
+		for _, puppet := range br.puppets {
+			if puppet.EnablePresence {
+				puppets = append(puppets, puppet)
+			}
+		}
+		br.puppetsLock.Unlock()
+		for _, puppet := range puppets {
+			puppet.presenceLock.Lock()
+			if puppet.presence == event.PresenceOnline {
+				puppet.sendPresence()
+			}
+			puppet.presenceLock.Unlock()
+		}
+	}
+}
+
+// sendPresence sends the presence and custom status of the puppet's ghost. The caller must hold the presence lock.
+func (puppet *Puppet) sendPresence() {
+	presence := puppet.presence
+	if presence == "" {
+		presence = event.PresenceOffline
+	}
+	intent := puppet.DefaultIntent()
+	url := intent.BuildClientURL("v3", "presence", intent.UserID, "status")
+	_, err := intent.MakeRequest(http.MethodPut, url, &event.PresenceEventContent{
+		Presence:      presence,
+		StatusMessage: puppet.statusMessage,
+	}, nil)
+	if err != nil {
+		puppet.log.Warnfln("Failed to set presence to %s: %v", presence, err)
+	}
+}
+
+// formatStatus formats a Slack custom status the same way the Slack client shows it.
+func (br *SlackBridge) formatStatus(text, emoji string, userTeam *database.UserTeam) string {
+	return strings.TrimSpace(br.ReplaceEmojiShortcodes(emoji, userTeam) + " " + br.ReplaceEmojiShortcodes(text, userTeam))
+}
+
+// UpdateStatus stores the Slack custom status of the puppet and shows it in the ghost's presence and,
+// on Beeper, in its profile. Statuses with an expiration are cleared automatically when they expire.
+func (puppet *Puppet) UpdateStatus(userTeam *database.UserTeam, text, emoji string, expiration int64) bool {
+	if expiration > 0 && time.Unix(expiration, 0).Before(time.Now()) {
+		text, emoji, expiration = "", "", 0
+	}
+	changed := puppet.StatusText != text || puppet.StatusEmoji != emoji || puppet.StatusExpiration != expiration
+	puppet.StatusText = text
+	puppet.StatusEmoji = emoji
+	puppet.StatusExpiration = expiration
+	statusMessage := puppet.bridge.formatStatus(text, emoji, userTeam)
+
+	puppet.presenceLock.Lock()
+	if puppet.statusExpiryTimer != nil {
+		puppet.statusExpiryTimer.Stop()
+		puppet.statusExpiryTimer = nil
+	}
+	if expiration > 0 {
+		puppet.statusExpiryTimer = time.AfterFunc(time.Until(time.Unix(expiration, 0)), puppet.expireStatus)
+	}
+	statusChanged := puppet.statusMessage != statusMessage
+	puppet.statusMessage = statusMessage
+	if statusChanged && puppet.EnablePresence {
+		puppet.sendPresence()
+	}
+	puppet.presenceLock.Unlock()
+
+	if changed && puppet.bridge.Config.Homeserver.Software == bridgeconfig.SoftwareHungry {
+		err := puppet.DefaultIntent().BeeperUpdateProfile(map[string]any{
+			"com.beeper.bridge.status_text":       text,
+			"com.beeper.bridge.status_emoji":      puppet.bridge.ReplaceEmojiShortcodes(emoji, userTeam),
+			"com.beeper.bridge.status_expires_at": expiration * 1000,
+		})
+		if err != nil {
+			puppet.log.Warnln("Failed to store custom status in profile:", err)
+		}
+	}
+	return changed
+}
+
+func (puppet *Puppet) expireStatus() {
+	puppet.syncLock.Lock()
+	defer puppet.syncLock.Unlock()
+	if puppet.StatusExpiration == 0 || time.Unix(puppet.StatusExpiration, 0).After(time.Now()) {
+		return
+	}
+	puppet.log.Debugln("Custom status expired, clearing it")
+	if puppet.UpdateStatus(nil, "", "", 0) {
+		puppet.Update()
+	}
+}
+
+// HandleMatrixPresence handles presence events that the homeserver sends to the appservice, so that
+// presence and status are bridged to Slack without having to sync with the double puppets.
+func (br *SlackBridge) HandleMatrixPresence(evt *event.Event) {
+	puppet := br.GetPuppetByCustomMXID(evt.Sender)
+	if puppet == nil || !puppet.EnablePresence || puppet.customUser == nil {
+		return
+	}
+	if evt.Content.Parsed == nil {
+		if err := evt.Content.ParseRaw(evt.Type); err != nil {
+			return
+		}
+	}
+	go puppet.handleMatrixPresence(evt)
+}
+
+// handleMatrixPresence sends the Matrix presence and status message of a double puppeted user to Slack.
+func (puppet *Puppet) handleMatrixPresence(evt *event.Event) {
+	userTeam := puppet.customUser.GetUserTeam(puppet.TeamID)
+	if userTeam == nil || userTeam.Client() == nil || userTeam.Key.SlackID != puppet.UserID {
+		return
+	}
+	slackPresence := slackPresenceAway
+	if evt.Content.AsPresence().Presence == event.PresenceOnline {
+		slackPresence = slackPresenceAuto
+	}
+
+	puppet.presenceLock.Lock()
+	defer puppet.presenceLock.Unlock()
+	if puppet.matrixPresence != slackPresence {
+		err := userTeam.Client().SetUserPresence(slackPresence)
+		if err != nil {
+			puppet.log.Warnfln("Failed to set Slack presence of %s to %s: %v", puppet.CustomMXID, slackPresence, err)
+		} else {
+			puppet.log.Debugfln("Set Slack presence of %s to %s", puppet.CustomMXID, slackPresence)
+			puppet.matrixPresence = slackPresence
+		}
+	}
+
+	statusMessage := evt.Content.AsPresence().StatusMessage
+	if puppet.matrixStatus == nil {
+		// Don't overwrite the Slack status with whatever was on Matrix before the bridge started syncing
+		puppet.matrixStatus = &statusMessage
+	} else if *puppet.matrixStatus != statusMessage {
+		err := userTeam.Client().SetUserCustomStatus(statusMessage, "", 0)
+		if err != nil {
+			puppet.log.Warnfln("Failed to set Slack status of %s: %v", puppet.CustomMXID, err)
+		} else {
+			puppet.log.Debugfln("Set Slack status of %s to %q", puppet.CustomMXID, statusMessage)
+			puppet.matrixStatus = &statusMessage
+		}
+	}
+}
+
+func (user *User) handlePresenceChange(userTeam *database.UserTeam, evt *slack.PresenceChangeEvent) {
+	userIDs := evt.Users
+	if evt.User != "" {
+		userIDs = append(userIDs, evt.User)
+	}
+	for _, userID := range userIDs {
+		puppet := user.bridge.GetPuppetByID(userTeam.Key.TeamID, userID)
+		if puppet != nil {
+			puppet.UpdatePresence(evt.Presence)
+		}
+	}
+}
+
+// subscribePresence asks Slack to send presence changes of the team's users. This is only needed
+// (and possible) with RTM, and has to be done again after every reconnection.
+func (user *User) subscribePresence(userTeam *database.UserTeam) {
+	if userTeam.RTM == nil {
+		return
+	}
+	var userIDs []string
+	for _, puppet := range user.bridge.GetAllPuppetsForTeam(userTeam.Key.TeamID) {
+		if !puppet.IsBot && puppet.EnablePresence {
+			userIDs = append(userIDs, puppet.UserID)
+		}
+	}
+	if len(userIDs) > 0 {
+		user.log.Debugfln("Subscribing to presence of %d users in %s", len(userIDs), userTeam.Key.TeamID)
+		userTeam.RTM.SendMessage(userTeam.RTM.NewSubscribeUserPresence(userIDs))
+	}
+}
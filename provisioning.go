@@ -28,11 +28,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	log "maunium.net/go/maulogger/v2"
 
 	"maunium.net/go/mautrix/bridge/status"
 	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/database"
 )
 
 const (
@@ -61,6 +64,10 @@ func newProvisioningAPI(br *SlackBridge) *ProvisioningAPI {
 	r.HandleFunc("/v1/ping", p.ping).Methods(http.MethodGet)
 	r.HandleFunc("/v1/login", p.login).Methods(http.MethodPost)
 	r.HandleFunc("/v1/logout", p.logout).Methods(http.MethodPost)
+	r.HandleFunc("/v1/rooms/{roomID}/backfill", p.backfill).Methods(http.MethodPost)
+	r.HandleFunc("/v1/rooms/{roomID}/create", p.createChannel).Methods(http.MethodPost)
+	r.HandleFunc("/v1/rooms/{roomID}/bridge", p.bridgeRoom).Methods(http.MethodPost)
+	r.HandleFunc("/v1/rooms/{roomID}/unbridge", p.unbridgeRoom).Methods(http.MethodPost)
 	p.bridge.AS.Router.HandleFunc("/_matrix/app/com.beeper.asmux/ping", p.BridgeStatePing).Methods(http.MethodPost)
 	p.bridge.AS.Router.HandleFunc("/_matrix/app/com.beeper.bridge_state", p.BridgeStatePing).Methods(http.MethodPost)
 
@@ -264,6 +271,240 @@ func (p *ProvisioningAPI) login(w http.ResponseWriter, r *http.Request) {
 		})
 }
 
+func (p *ProvisioningAPI) backfill(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(*User)
+
+	var data struct {
+		Count int
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&data)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, Error{
+				Error:   "Invalid JSON",
+				ErrCode: "Invalid JSON",
+			})
+			return
+		}
+	}
+	if data.Count < 0 {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   "Invalid message count",
+			ErrCode: "Invalid message count",
+		})
+		return
+	}
+
+	portal := p.bridge.GetPortalByMXID(id.RoomID(mux.Vars(r)["roomID"]))
+	if portal == nil {
+		jsonResponse(w, http.StatusNotFound, Error{
+			Error:   "Room is not a portal",
+			ErrCode: "Room is not a portal",
+		})
+		return
+	}
+	err := portal.checkBackfillAccess(user)
+	if errors.Is(err, errBackfillNotLoggedIn) {
+		jsonResponse(w, http.StatusForbidden, Error{
+			Error:   "Not logged into the Slack team of the room",
+			ErrCode: "Not logged in",
+		})
+		return
+	} else if err != nil {
+		jsonResponse(w, http.StatusForbidden, Error{
+			Error:   "Not in the room",
+			ErrCode: "Not in room",
+		})
+		return
+	}
+
+	err = portal.RequestBackfill(data.Count)
+	if errors.Is(err, errBackfillInProgress) {
+		jsonResponse(w, http.StatusConflict, Error{
+			Error:   err.Error(),
+			ErrCode: "Backfill in progress",
+		})
+		return
+	} else if errors.Is(err, errBackfillLimitReached) {
+		jsonResponse(w, http.StatusConflict, Error{
+			Error:   err.Error(),
+			ErrCode: "Backfill limit reached",
+		})
+		return
+	} else if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   err.Error(),
+			ErrCode: "Backfill disabled",
+		})
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, Response{true, "Backfill queued"})
+}
+
+// getUserTeam finds the user team for a request that may contain a team ID.
+func (p *ProvisioningAPI) getUserTeam(w http.ResponseWriter, user *User, teamID string) *database.UserTeam {
+	var userTeam *database.UserTeam
+	if teamID != "" {
+		userTeam = user.GetUserTeam(teamID)
+	} else if teams := user.GetLoggedInTeams(); len(teams) == 1 {
+		userTeam = teams[0]
+	} else {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   "Logged into multiple teams, team_id is required",
+			ErrCode: "Missing field team_id",
+		})
+		return nil
+	}
+	if userTeam == nil || !userTeam.IsConnected() {
+		jsonResponse(w, http.StatusForbidden, Error{
+			Error:   "Not logged into the Slack team",
+			ErrCode: "Not logged in",
+		})
+		return nil
+	}
+	return userTeam
+}
+
+func (p *ProvisioningAPI) createChannel(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(*User)
+
+	var data struct {
+		TeamID  string `json:"team_id"`
+		Name    string `json:"name"`
+		Private bool   `json:"private"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&data)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, Error{
+				Error:   "Invalid JSON",
+				ErrCode: "Invalid JSON",
+			})
+			return
+		}
+	}
+	userTeam := p.getUserTeam(w, user, data.TeamID)
+	if userTeam == nil {
+		return
+	}
+
+	roomID := id.RoomID(mux.Vars(r)["roomID"])
+	portal, err := user.CreateChannelForRoom(roomID, userTeam, data.Name, data.Private)
+	if portal == nil {
+		roomBridgeErrorResponse(w, err, "Failed to create channel")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"success":    true,
+		"team_id":    portal.Key.TeamID,
+		"channel_id": portal.Key.ChannelID,
+		"name":       portal.PlainName,
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	jsonResponse(w, http.StatusCreated, resp)
+}
+
+// roomBridgeErrorResponse responds with the appropriate status for an error from bridging a room.
+func roomBridgeErrorResponse(w http.ResponseWriter, err error, errCode string) {
+	switch {
+	case errors.Is(err, errRoomAlreadyBridged), errors.Is(err, errChannelAlreadyBridged):
+		jsonResponse(w, http.StatusConflict, Error{
+			Error:   err.Error(),
+			ErrCode: "Already bridged",
+		})
+	case errors.Is(err, errBotMissingPermissions), errors.Is(err, errUserMissingPermissions), errors.Is(err, errManagementRoom):
+		jsonResponse(w, http.StatusForbidden, Error{
+			Error:   err.Error(),
+			ErrCode: "M_FORBIDDEN",
+		})
+	case errors.Is(err, errChannelNotFound):
+		jsonResponse(w, http.StatusNotFound, Error{
+			Error:   err.Error(),
+			ErrCode: "Channel not found",
+		})
+	default:
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   err.Error(),
+			ErrCode: errCode,
+		})
+	}
+}
+
+func (p *ProvisioningAPI) bridgeRoom(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(*User)
+
+	var data struct {
+		TeamID   string `json:"team_id"`
+		Channel  string `json:"channel"`
+		Backfill bool   `json:"backfill"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   "Invalid JSON",
+			ErrCode: "Invalid JSON",
+		})
+		return
+	} else if data.Channel == "" {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   "Missing field channel",
+			ErrCode: "Missing field channel",
+		})
+		return
+	}
+	userTeam := p.getUserTeam(w, user, data.TeamID)
+	if userTeam == nil {
+		return
+	}
+
+	roomID := id.RoomID(mux.Vars(r)["roomID"])
+	portal, err := user.BridgeRoom(roomID, userTeam, data.Channel, data.Backfill)
+	if portal == nil {
+		roomBridgeErrorResponse(w, err, "Failed to bridge room")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"success":    true,
+		"team_id":    portal.Key.TeamID,
+		"channel_id": portal.Key.ChannelID,
+		"name":       portal.PlainName,
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	jsonResponse(w, http.StatusOK, resp)
+}
+
+func (p *ProvisioningAPI) unbridgeRoom(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(*User)
+
+	portal := p.bridge.GetPortalByMXID(id.RoomID(mux.Vars(r)["roomID"]))
+	if portal == nil {
+		jsonResponse(w, http.StatusNotFound, Error{
+			Error:   "Room is not a portal",
+			ErrCode: "Room is not a portal",
+		})
+		return
+	} else if portal.Type != database.ChannelTypeChannel {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   errNotAChannel.Error(),
+			ErrCode: "Not a channel",
+		})
+		return
+	}
+	err := portal.Unbridge(user)
+	if err != nil {
+		roomBridgeErrorResponse(w, err, "Failed to unbridge room")
+		return
+	}
+	jsonResponse(w, http.StatusOK, Response{true, "Room unbridged"})
+}
+
 func (p *ProvisioningAPI) BridgeStatePing(w http.ResponseWriter, r *http.Request) {
 	if !p.bridge.AS.CheckServerToken(w, r) {
 		return
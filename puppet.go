@@ -21,12 +21,14 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	log "maunium.net/go/maulogger/v2"
 
 	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/bridge"
 	"maunium.net/go/mautrix/bridge/bridgeconfig"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"github.com/slack-go/slack"
@@ -46,6 +48,15 @@ type Puppet struct {
 	customUser   *User
 
 	syncLock sync.Mutex
+
+	presenceLock sync.Mutex
+	// presence is the last presence set for the ghost, matrixPresence the last Slack presence set for the double puppet.
+	presence       event.Presence
+	matrixPresence string
+	// statusMessage is the formatted custom status of the ghost, matrixStatus the last status message of the double puppet.
+	statusMessage     string
+	matrixStatus      *string
+	statusExpiryTimer *time.Timer
 }
 
 var _ bridge.Ghost = (*Puppet)(nil)
@@ -99,7 +110,8 @@ func (br *SlackBridge) GetPuppetByMXID(mxid id.UserID) *Puppet {
 		return nil
 	}
 
-	return br.GetPuppetByID(team, id)
+	// Ghost MXIDs are lowercased, but Slack IDs are always uppercase
+	return br.GetPuppetByID(strings.ToUpper(team), strings.ToUpper(id))
 }
 
 func (br *SlackBridge) GetPuppetByID(teamID, userID string) *Puppet {
@@ -218,13 +230,13 @@ func (puppet *Puppet) updatePortalMeta(meta func(portal *Portal)) {
 
 func (puppet *Puppet) updateName(source *User) bool {
 	userTeam := source.GetUserTeam(puppet.TeamID)
-	user, err := userTeam.Client.GetUserInfo(puppet.UserID)
+	user, err := userTeam.Client().GetUserInfo(puppet.UserID)
 	if err != nil {
 		puppet.log.Warnln("failed to get user from id:", err)
 		return false
 	}
 
-	newName := puppet.bridge.Config.Bridge.FormatDisplayname(user, userTeam)
+	newName := puppet.bridge.ReplaceEmojiShortcodes(puppet.bridge.Config.Bridge.FormatDisplayname(user, userTeam), userTeam)
 
 	if puppet.Name != newName {
 		err := puppet.DefaultIntent().SetDisplayName(newName)
@@ -391,7 +403,7 @@ func (puppet *Puppet) UpdateInfo(userTeam *database.UserTeam, fetch bool, info *
 		var err error
 		puppet.log.Debugfln("Fetching info through team %s to update", userTeam.Key.TeamID)
 
-		info, err = userTeam.Client.GetUserInfo(puppet.UserID)
+		info, err = userTeam.Client().GetUserInfo(puppet.UserID)
 		if err != nil {
 			puppet.log.Errorfln("Failed to fetch info through %s: %v", userTeam.Key.TeamID, err)
 			return
@@ -406,14 +418,18 @@ func (puppet *Puppet) UpdateInfo(userTeam *database.UserTeam, fetch bool, info *
 	changed := false
 
 	if info != nil {
-		newName := puppet.bridge.Config.Bridge.FormatDisplayname(info, userTeam)
+		newName := puppet.bridge.ReplaceEmojiShortcodes(puppet.bridge.Config.Bridge.FormatDisplayname(info, userTeam), userTeam)
 		changed = puppet.UpdateName(newName) || changed
 		changed = puppet.UpdateAvatar(info.Profile.ImageOriginal) || changed
+		changed = puppet.UpdateStatus(userTeam, info.Profile.StatusText, info.Profile.StatusEmoji, int64(info.Profile.StatusExpiration)) || changed
 
 		if (info.IsBot || info.IsAppUser) && !puppet.IsBot {
 			puppet.IsBot = true
 			changed = true
 		}
+	} else if puppet.StatusExpiration > 0 {
+		// Make sure a stored status still gets cleared when it expires
+		changed = puppet.UpdateStatus(userTeam, puppet.StatusText, puppet.StatusEmoji, puppet.StatusExpiration) || changed
 	}
 	changed = puppet.UpdateContactInfo(puppet.IsBot || strings.ToLower(puppet.UserID) == "uslackbot") || changed
 
@@ -450,7 +466,7 @@ func (puppet *Puppet) UpdateInfoBot(userTeam *database.UserTeam) {
 	defer puppet.syncLock.Unlock()
 
 	puppet.log.Debugfln("Fetching bot info through team %s to update", userTeam.Key.TeamID)
-	info, err := userTeam.Client.GetBotInfo(puppet.UserID)
+	info, err := userTeam.Client().GetBotInfo(puppet.UserID)
 	if err != nil {
 		puppet.log.Errorfln("Failed to fetch bot info through %s: %v", userTeam.Key.TeamID, err)
 		return
@@ -463,7 +479,7 @@ func (puppet *Puppet) UpdateInfoBot(userTeam *database.UserTeam) {
 
 	changed := false
 
-	newName := puppet.bridge.Config.Bridge.FormatBotDisplayname(info)
+	newName := puppet.bridge.ReplaceEmojiShortcodes(puppet.bridge.Config.Bridge.FormatBotDisplayname(info), userTeam)
 	changed = puppet.UpdateName(newName) || changed
 	changed = puppet.UpdateAvatar(info.Icons.Image72) || changed
 	changed = puppet.UpdateContactInfo(true) || changed
@@ -0,0 +1,350 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2022 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/database"
+)
+
+var (
+	errRoomAlreadyBridged     = errors.New("this room is already bridged to a Slack conversation")
+	errChannelAlreadyBridged  = errors.New("that Slack conversation is already bridged to another room")
+	errManagementRoom         = errors.New("management rooms can't be bridged")
+	errNotAChannel            = errors.New("only channels can be bridged to existing rooms or unbridged")
+	errMissingRoomName        = errors.New("the room doesn't have a name to use for the channel")
+	errChannelNotFound        = errors.New("channel not found")
+	errBotMissingPermissions  = errors.New("the bridge bot doesn't have permission to change the room state and invite users")
+	errUserMissingPermissions = errors.New("you don't have permission to change the room state")
+	errPortalUnbridged        = errors.New("the channel was unbridged, bridge it to a room to create a portal again")
+	errChannelBeingBridged    = errors.New("the channel is being bridged to an existing room")
+)
+
+// checkRoomBridgePermissions checks that a Matrix room can be turned into a portal: the bridge bot
+// must be able to write the bridge info and invite ghosts, and the user must be allowed to change
+// the room state themselves.
+func (br *SlackBridge) checkRoomBridgePermissions(roomID id.RoomID, user *User) error {
+	if roomID == user.ManagementRoom {
+		return errManagementRoom
+	} else if br.GetPortalByMXID(roomID) != nil {
+		return errRoomAlreadyBridged
+	}
+	return br.checkRoomPowerLevels(roomID, user)
+}
+
+func (br *SlackBridge) checkRoomPowerLevels(roomID id.RoomID, user *User) error {
+	levels, err := br.Bot.PowerLevels(roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get room power levels: %w", err)
+	}
+	stateLevel := levels.GetEventLevel(event.StateBridge)
+	botLevel := levels.GetUserLevel(br.Bot.UserID)
+	if botLevel < stateLevel || botLevel < levels.Invite() {
+		return errBotMissingPermissions
+	} else if levels.GetUserLevel(user.MXID) < stateLevel {
+		return errUserMissingPermissions
+	}
+	return nil
+}
+
+// getRoomSlackUsers returns the IDs of the Slack users in the team whose ghosts are in the room.
+func (br *SlackBridge) getRoomSlackUsers(roomID id.RoomID, teamID string) ([]string, error) {
+	members, err := br.Bot.JoinedMembers(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room members: %w", err)
+	}
+	var slackIDs []string
+	for userID := range members.Joined {
+		puppet := br.GetPuppetByMXID(userID)
+		if puppet != nil && puppet.TeamID == teamID {
+			slackIDs = append(slackIDs, puppet.UserID)
+		}
+	}
+	return slackIDs, nil
+}
+
+// markChannelCreatePending marks a channel that is about to be created for an existing room, so
+// that the events about the new channel don't create another room for it. The returned function
+// removes the mark, and must be called after the room has been bridged.
+func (br *SlackBridge) markChannelCreatePending(teamID, name string) (func(), bool) {
+	key := teamID + "-" + name
+	br.pendingChannelCreatesLock.Lock()
+	defer br.pendingChannelCreatesLock.Unlock()
+	if _, pending := br.pendingChannelCreates[key]; pending {
+		return nil, false
+	}
+	br.pendingChannelCreates[key] = struct{}{}
+	return func() {
+		br.pendingChannelCreatesLock.Lock()
+		delete(br.pendingChannelCreates, key)
+		br.pendingChannelCreatesLock.Unlock()
+	}, true
+}
+
+func (br *SlackBridge) isChannelCreatePending(teamID, name string) bool {
+	br.pendingChannelCreatesLock.Lock()
+	defer br.pendingChannelCreatesLock.Unlock()
+	_, pending := br.pendingChannelCreates[teamID+"-"+name]
+	return pending
+}
+
+// bridgeExistingRoom turns an existing Matrix room into the portal of a Slack channel.
+// The caller must hold the portal's roomCreateLock.
+func (portal *Portal) bridgeExistingRoom(roomID id.RoomID, user *User, userTeam *database.UserTeam, channel *slack.Channel) error {
+	if portal.MXID != "" {
+		return errChannelAlreadyBridged
+	} else if !portal.setChannelType(channel) || portal.Type != database.ChannelTypeChannel {
+		return errNotAChannel
+	}
+
+	var encryption event.EncryptionEventContent
+	err := portal.MainIntent().StateEvent(roomID, event.StateEncryption, "", &encryption)
+	if err == nil && encryption.Algorithm == id.AlgorithmMegolmV1 {
+		portal.Encrypted = true
+	}
+
+	portal.log.Infofln("Bridging existing room %s to channel %s as requested by %s", roomID, portal.Key.ChannelID, user.MXID)
+	portal.MXID = roomID
+	portal.Unbridged = false
+	portal.NameSet = false
+	portal.TopicSet = false
+	portal.bridge.portalsLock.Lock()
+	portal.bridge.portalsByMXID[portal.MXID] = portal
+	portal.bridge.portalsLock.Unlock()
+
+	portal.UpdateInfo(user, userTeam, channel, true)
+	portal.InsertUser(userTeam.Key)
+	portal.syncParticipants(user, userTeam, portal.getChannelMembers(userTeam), true)
+	user.updateChatMute(portal, true)
+
+	// Backwards backfill inserts history before this event, like in rooms created by the bridge
+	firstEventResp, err := portal.MainIntent().SendMessageEvent(portal.MXID, portalCreationDummyEvent, struct{}{})
+	if err != nil {
+		portal.log.Errorln("Failed to send dummy event to mark portal creation:", err)
+	} else {
+		portal.FirstEventID = firstEventResp.EventID
+		portal.Update(nil)
+	}
+	return nil
+}
+
+// CreateChannelForRoom creates a new Slack channel with the Slack users whose ghosts are in the
+// room, and bridges the room to it. The channel is named after the room if no name is given.
+// If inviting the users fails, the portal is returned along with the error.
+func (user *User) CreateChannelForRoom(roomID id.RoomID, userTeam *database.UserTeam, name string, private bool) (*Portal, error) {
+	err := user.bridge.checkRoomBridgePermissions(roomID, user)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		var roomName event.RoomNameEventContent
+		_ = user.bridge.Bot.StateEvent(roomID, event.StateRoomName, "", &roomName)
+		name = roomName.Name
+	}
+	name = slackChannelName(name)
+	if name == "" {
+		return nil, errMissingRoomName
+	}
+	members, err := user.bridge.getRoomSlackUsers(roomID, userTeam.Key.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The channel_created and channel_joined events may arrive before the room is bridged,
+	// so mark the channel as pending to stop them from creating a new room for it.
+	donePending, ok := user.bridge.markChannelCreatePending(userTeam.Key.TeamID, name)
+	if !ok {
+		return nil, errChannelBeingBridged
+	}
+	user.log.Infofln("Creating Slack channel %q in %s for room %s", name, userTeam.Key.TeamID, roomID)
+	channel, err := userTeam.Client().CreateConversation(slack.CreateConversationParams{
+		ChannelName: name,
+		IsPrivate:   private,
+	})
+	if err != nil {
+		donePending()
+		return nil, fmt.Errorf("failed to create channel: %w", err)
+	}
+
+	portal := user.bridge.GetPortalByID(database.NewPortalKey(userTeam.Key.TeamID, channel.ID))
+	portal.roomCreateLock.Lock()
+	err = portal.bridgeExistingRoom(roomID, user, userTeam, channel)
+	portal.roomCreateLock.Unlock()
+	donePending()
+	if err != nil {
+		return nil, err
+	}
+
+	var invite []string
+	for _, member := range members {
+		if member != userTeam.Key.SlackID {
+			invite = append(invite, member)
+		}
+	}
+	if len(invite) > 0 {
+		// The ghosts are already in the room, so the member_joined_channel events don't need to do anything
+		_, err = userTeam.Client().InviteUsersToConversation(channel.ID, invite...)
+		if err != nil {
+			portal.log.Warnfln("Failed to invite %v to the new channel: %v", invite, err)
+			return portal, fmt.Errorf("failed to invite users to the channel: %w", err)
+		}
+	}
+	return portal, nil
+}
+
+// findChannel finds a channel that the user can see by its ID or its name prefixed with #.
+func (user *User) findChannel(userTeam *database.UserTeam, channelRef string) (*slack.Channel, error) {
+	if !strings.HasPrefix(channelRef, "#") {
+		channel, err := userTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{
+			ChannelID:         channelRef,
+			IncludeLocale:     true,
+			IncludeNumMembers: true,
+		})
+		if err != nil && err.Error() == "channel_not_found" {
+			return nil, errChannelNotFound
+		}
+		return channel, err
+	}
+
+	name := strings.TrimPrefix(channelRef, "#")
+	params := &slack.GetConversationsParameters{
+		ExcludeArchived: true,
+		Limit:           channelMembersPageSize,
+		Types:           []string{"public_channel", "private_channel"},
+	}
+	for {
+		channels, nextCursor, err := userTeam.Client().GetConversations(params)
+		if err != nil {
+			return nil, err
+		}
+		for i := range channels {
+			if channels[i].Name == name {
+				return &channels[i], nil
+			}
+		}
+		if nextCursor == "" {
+			return nil, errChannelNotFound
+		}
+		params.Cursor = nextCursor
+	}
+}
+
+// BridgeRoom bridges an existing Matrix room to a Slack channel, joining the channel first if
+// it's public and the user isn't in it yet. Backfilling is optional, as the room may already
+// have its own history.
+func (user *User) BridgeRoom(roomID id.RoomID, userTeam *database.UserTeam, channelRef string, backfill bool) (*Portal, error) {
+	err := user.bridge.checkRoomBridgePermissions(roomID, user)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := user.findChannel(userTeam, channelRef)
+	if err != nil {
+		return nil, err
+	}
+	portal := user.bridge.GetPortalByID(database.NewPortalKey(userTeam.Key.TeamID, channel.ID))
+	// Hold the lock while joining, so that the channel_joined event can't create a room for the channel first
+	portal.roomCreateLock.Lock()
+	if portal.MXID != "" {
+		portal.roomCreateLock.Unlock()
+		return nil, errChannelAlreadyBridged
+	}
+	if !channel.IsMember && !channel.IsPrivate {
+		user.log.Debugfln("Joining %s before bridging it to %s", channel.ID, roomID)
+		channel, _, _, err = userTeam.Client().JoinConversation(channel.ID)
+		if err != nil {
+			portal.roomCreateLock.Unlock()
+			return nil, fmt.Errorf("failed to join channel: %w", err)
+		}
+	}
+
+	err = portal.bridgeExistingRoom(roomID, user, userTeam, channel)
+	portal.roomCreateLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if backfill {
+		err = portal.RequestBackfill(0)
+		if err != nil {
+			return portal, fmt.Errorf("failed to queue backfill: %w", err)
+		}
+	}
+	return portal, nil
+}
+
+// Unbridge detaches the portal from its Matrix room without deleting the room. The ghosts leave
+// the room, but the bridge bot stays so that the room can be bridged again. The channel is marked
+// as unbridged, so new messages don't create a new room for it until it's bridged again.
+func (portal *Portal) Unbridge(user *User) error {
+	if portal.Type != database.ChannelTypeChannel {
+		return errNotAChannel
+	}
+	err := portal.bridge.checkRoomPowerLevels(portal.MXID, user)
+	if err != nil {
+		return err
+	}
+	portal.roomCreateLock.Lock()
+	defer portal.roomCreateLock.Unlock()
+	if portal.MXID == "" {
+		return errPortalUnbridged
+	}
+
+	portal.log.Infofln("Unbridging %s as requested by %s", portal.MXID, user.MXID)
+	stateKey, _ := portal.getBridgeInfo()
+	intent := portal.MainIntent()
+	_, err = intent.SendStateEvent(portal.MXID, event.StateBridge, stateKey, struct{}{})
+	if err != nil {
+		portal.log.Warnln("Failed to clear m.bridge:", err)
+	}
+	_, err = intent.SendStateEvent(portal.MXID, event.StateHalfShotBridge, stateKey, struct{}{})
+	if err != nil {
+		portal.log.Warnln("Failed to clear uk.half-shot.bridge:", err)
+	}
+
+	members, err := portal.bridge.Bot.JoinedMembers(portal.MXID)
+	if err != nil {
+		portal.log.Warnln("Failed to get room members to remove ghosts:", err)
+	} else {
+		for member := range members.Joined {
+			puppet := portal.bridge.GetPuppetByMXID(member)
+			if puppet == nil {
+				continue
+			}
+			_, err = puppet.DefaultIntent().LeaveRoom(portal.MXID)
+			if err != nil {
+				portal.log.Warnfln("Failed to make %s leave while unbridging: %v", member, err)
+			}
+		}
+	}
+
+	roomID := portal.MXID
+	err = portal.Portal.Unbridge()
+	if err != nil {
+		return fmt.Errorf("failed to mark portal as unbridged: %w", err)
+	}
+	portal.bridge.portalsLock.Lock()
+	delete(portal.bridge.portalsByMXID, roomID)
+	portal.bridge.portalsLock.Unlock()
+	return nil
+}
@@ -0,0 +1,289 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2022 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"go.mau.fi/mautrix-slack/database"
+)
+
+// exportChannel is a conversation in a Slack export, along with the directory its history is stored in.
+type exportChannel struct {
+	slack.Channel
+	dir string
+}
+
+// slackExport is a workspace export ZIP as produced by Slack's export tool.
+type slackExport struct {
+	zip      *zip.ReadCloser
+	users    []slack.User
+	channels []exportChannel
+}
+
+func openSlackExport(filePath string) (*slackExport, error) {
+	reader, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %w", err)
+	}
+	export := &slackExport{zip: reader}
+	if err = export.readJSON("users.json", &export.users); err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	// Public channels are always included, the other files only exist in some types of exports
+	for _, file := range []string{"channels.json", "groups.json", "mpims.json", "dms.json"} {
+		var channels []exportChannel
+		err = export.readJSON(file, &channels)
+		if errors.Is(err, fs.ErrNotExist) && file != "channels.json" {
+			continue
+		} else if err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+		for i := range channels {
+			channel := &channels[i]
+			switch file {
+			case "channels.json":
+				channel.IsChannel = true
+			case "groups.json":
+				channel.IsGroup = true
+				channel.IsPrivate = true
+			case "mpims.json":
+				channel.IsMpIM = true
+			case "dms.json":
+				channel.IsIM = true
+			}
+			if channel.IsIM {
+				channel.dir = channel.ID
+			} else {
+				channel.dir = channel.Name
+			}
+		}
+		export.channels = append(export.channels, channels...)
+	}
+	return export, nil
+}
+
+func (export *slackExport) Close() error {
+	return export.zip.Close()
+}
+
+func (export *slackExport) readJSON(name string, into interface{}) error {
+	file, err := export.zip.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+	if err = json.NewDecoder(file).Decode(into); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// readMessages reads all the per-day history files of a channel, oldest first.
+func (export *slackExport) readMessages(channel *exportChannel) ([]slack.Message, error) {
+	var files []string
+	for _, file := range export.zip.File {
+		if path.Dir(file.Name) == channel.dir && path.Ext(file.Name) == ".json" {
+			files = append(files, file.Name)
+		}
+	}
+	// The files are named by date, so sorting them puts them in chronological order
+	sort.Strings(files)
+	var messages []slack.Message
+	for _, file := range files {
+		var dayMessages []slack.Message
+		if err := export.readJSON(file, &dayMessages); err != nil {
+			return nil, err
+		}
+		messages = append(messages, dayMessages...)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return parseSlackTimestamp(messages[i].Timestamp).Before(parseSlackTimestamp(messages[j].Timestamp))
+	})
+	return messages, nil
+}
+
+// ImportSlackExport creates portals for the conversations in a Slack export and backfills their history
+// from the export. The user must be logged into the exported team, as the login is used for things that
+// aren't included in exports, like files and custom emoji.
+func (user *User) ImportSlackExport(userTeam *database.UserTeam, filePath string, reply func(string, ...interface{})) error {
+	export, err := openSlackExport(filePath)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	reply("Found %d users and %d conversations in export, importing...", len(export.users), len(export.channels))
+	for _, info := range export.users {
+		if info.TeamID != "" && info.TeamID != userTeam.Key.TeamID {
+			continue
+		}
+		puppet := user.bridge.GetPuppetByID(userTeam.Key.TeamID, info.ID)
+		if puppet != nil {
+			puppet.UpdateInfo(userTeam, false, &info)
+		}
+	}
+
+	var imported int
+	for i := range export.channels {
+		channel := &export.channels[i]
+		count, err := user.importExportChannel(userTeam, export, channel)
+		if err != nil {
+			user.log.Warnfln("Failed to import %s from Slack export: %v", channel.ID, err)
+			reply("Failed to import %s: %v", importChannelName(channel), err)
+		} else if count > 0 {
+			imported++
+			reply("Imported %d messages into %s", count, importChannelName(channel))
+		}
+	}
+	reply("Finished importing Slack export, %d conversations had new messages", imported)
+	return nil
+}
+
+// isExportChannelMember checks whether the user is in an exported conversation. Exports of public channels
+// include every channel in the workspace, so only the ones the user is in are imported. If the export
+// doesn't list the members, the membership is checked on Slack.
+func (user *User) isExportChannelMember(userTeam *database.UserTeam, channel *exportChannel) (bool, error) {
+	if len(channel.Members) == 0 && !channel.IsIM {
+		var info *slack.Channel
+		err := user.bridge.BackfillQueue.CallWithRateLimit(userTeam.Key.TeamID, func() (err error) {
+			info, err = userTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel.ID})
+			return
+		})
+		if err != nil && err.Error() == "channel_not_found" {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failed to check channel membership: %w", err)
+		}
+		return info.IsMember, nil
+	}
+	var isMember bool
+	for _, member := range channel.Members {
+		if member == userTeam.Key.SlackID {
+			isMember = true
+		} else if channel.IsIM {
+			channel.User = member
+		}
+	}
+	return isMember, nil
+}
+
+func (user *User) importExportChannel(userTeam *database.UserTeam, export *slackExport, channel *exportChannel) (int, error) {
+	if isMember, err := user.isExportChannelMember(userTeam, channel); err != nil {
+		return 0, err
+	} else if !isMember {
+		return 0, nil
+	}
+
+	messages, err := export.readMessages(channel)
+	if err != nil {
+		return 0, err
+	} else if len(messages) == 0 {
+		return 0, nil
+	}
+
+	portal := user.bridge.GetPortalByID(database.PortalKey{TeamID: userTeam.Key.TeamID, ChannelID: channel.ID})
+	if portal.MXID == "" {
+		err = portal.CreateMatrixRoom(user, userTeam, &channel.Channel, false)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create room: %w", err)
+		} else if portal.MXID == "" {
+			return 0, fmt.Errorf("room wasn't created")
+		}
+	} else {
+		portal.ensureUserInvited(user)
+	}
+	portal.InsertUser(userTeam.Key)
+
+	portal.backfillLock.Lock()
+	defer portal.backfillLock.Unlock()
+
+	// Thread replies are stored next to the other messages in exports, so separate them
+	var topLevel []slack.Message
+	threads := make(map[string][]slack.Message)
+	bridgedRoots := make(map[string]bool)
+	for _, message := range messages {
+		if portal.getBackfilledEventID(message.Timestamp) != "" {
+			if message.ThreadTimestamp == message.Timestamp {
+				bridgedRoots[message.Timestamp] = true
+			}
+			continue
+		} else if message.ThreadTimestamp != "" && message.ThreadTimestamp != message.Timestamp {
+			if isBackfillableMessage(&message) {
+				threads[message.ThreadTimestamp] = append(threads[message.ThreadTimestamp], message)
+			}
+		} else {
+			topLevel = append(topLevel, message)
+		}
+	}
+
+	batchSize := user.bridge.Config.Bridge.Backfill.Incremental.MessagesPerBatch
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	// Send the newest batch first like normal backfill does, in the newest-first order Slack uses
+	var count int
+	for end := len(topLevel); end > 0; end -= batchSize {
+		start := end - batchSize
+		if start < 0 {
+			start = 0
+		}
+		batch := make([]slack.Message, 0, end-start)
+		for i := end - 1; i >= start; i-- {
+			batch = append(batch, topLevel[i])
+		}
+		_, err = portal.backfillWithThreads(userTeam, batch, threads, false)
+		if err != nil {
+			return count, fmt.Errorf("failed to send batch: %w", err)
+		}
+		count += len(batch)
+	}
+
+	// Replies to threads whose root was already bridged aren't part of any batch above
+	var existingRoots []ConvertedSlackMessage
+	for rootTs := range bridgedRoots {
+		if replies := threads[rootTs]; len(replies) > 0 {
+			existingRoots = append(existingRoots, ConvertedSlackMessage{SlackTimestamp: rootTs, SlackThread: replies})
+			count += len(replies)
+		}
+	}
+	if len(existingRoots) > 0 {
+		sort.Slice(existingRoots, func(i, j int) bool {
+			return parseSlackTimestamp(existingRoots[i].SlackTimestamp).Before(parseSlackTimestamp(existingRoots[j].SlackTimestamp))
+		})
+		portal.backfillThreads(userTeam, existingRoots, false, false)
+	}
+	return count, nil
+}
+
+func importChannelName(channel *exportChannel) string {
+	if channel.IsIM || channel.IsMpIM {
+		return channel.ID
+	}
+	return "#" + strings.TrimPrefix(channel.Name, "#")
+}
@@ -45,6 +45,20 @@ var (
 	ErrNotLoggedIn  = errors.New("not logged in")
 )
 
+// Event types that Slack sends over RTM, but which aren't in slack-go's event mapping.
+type MPIMOpenEvent slack.ChannelInfoEvent
+type MPIMJoinedEvent slack.ChannelJoinedEvent
+type GroupDeletedEvent slack.ChannelInfoEvent
+
+func init() {
+	slack.EventMapping["mpim_open"] = MPIMOpenEvent{}
+	slack.EventMapping["mpim_joined"] = MPIMJoinedEvent{}
+	slack.EventMapping["group_deleted"] = GroupDeletedEvent{}
+	// Custom status changes have their own event types in the Events API, but contain the same user object.
+	slack.EventMapping["user_status_changed"] = slack.UserChangeEvent{}
+	slack.EventMapping["user_profile_changed"] = slack.UserChangeEvent{}
+}
+
 type User struct {
 	*database.User
 
@@ -55,6 +69,11 @@ type User struct {
 
 	BridgeStates map[string]*bridge.BridgeStateQueue
 
+	teamConnections     map[string]*teamConnection
+	teamConnectionsLock sync.Mutex
+	// catchingUp contains the teams that are currently being forward backfilled, guarded by teamConnectionsLock.
+	catchingUp map[string]bool
+
 	PermissionLevel bridgeconfig.PermissionLevel
 }
 
@@ -163,6 +182,8 @@ func (br *SlackBridge) NewUser(dbUser *database.User) *User {
 
 	user.PermissionLevel = br.Config.Bridge.Permissions.Get(user.MXID)
 	user.BridgeStates = make(map[string]*bridge.BridgeStateQueue)
+	user.teamConnections = make(map[string]*teamConnection)
+	user.catchingUp = make(map[string]bool)
 
 	return user
 }
@@ -304,7 +325,7 @@ func (user *User) login(info *auth.Info, force bool) {
 
 	user.BridgeStates[info.TeamID] = user.bridge.NewBridgeStateQueue(userTeam)
 	user.bridge.usersByID[fmt.Sprintf("%s-%s", userTeam.Key.TeamID, userTeam.Key.SlackID)] = user
-	user.connectTeam(userTeam)
+	user.startTeamConnection(userTeam)
 }
 
 func (user *User) LoginTeam(email, team, password string) error {
@@ -356,18 +377,18 @@ func (user *User) LogoutUserTeam(userTeam *database.UserTeam) error {
 		}
 	}
 
-	if userTeam.RTM != nil {
-		if err := userTeam.RTM.Disconnect(); err != nil && !errors.Is(err, slack.ErrAlreadyDisconnected) {
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: err.Error()})
-			return err
-		}
+	if err := user.stopTeamConnection(userTeam.Key.TeamID); err != nil {
+		user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: err.Error()})
+		return err
 	}
 
-	if _, err := userTeam.Client.SendAuthSignout(); err != nil {
+	if userTeam.Client() == nil {
+		// The team never connected successfully, so there's no session to sign out of
+	} else if _, err := userTeam.Client().SendAuthSignout(); err != nil {
 		user.log.Errorfln("Failed to send auth.signout request to Slack! %v", err)
 	}
 
-	userTeam.Client = nil
+	userTeam.SetClient(nil)
 
 	user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateLoggedOut})
 
@@ -390,133 +411,209 @@ func (user *User) leavePortals(userTeam *database.UserTeam) {
 	}
 }
 
-func (user *User) slackMessageHandler(userTeam *database.UserTeam) {
+// slackMessageHandler handles events from the event source of the user team until the source
+// stops, and returns the reason it stopped.
+func (user *User) slackMessageHandler(userTeam *database.UserTeam, source SlackEventSource) error {
 	user.log.Debugfln("Start receiving Slack events for %s", userTeam.Key)
-	for msg := range userTeam.RTM.IncomingEvents {
-		switch event := msg.Data.(type) {
-		case *slack.ConnectingEvent:
-			user.log.Debugfln("connecting: attempt %d", event.Attempt)
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateConnecting})
-		case *slack.ConnectedEvent:
-			// Update all of our values according to what the server has for us.
-			userTeam.Key.SlackID = event.Info.User.ID
-			userTeam.Key.TeamID = event.Info.Team.ID
-			userTeam.TeamName = event.Info.Team.Name
-
-			userTeam.Upsert()
-
-			user.tryAutomaticDoublePuppeting(userTeam)
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateBackfilling})
-
-			user.log.Infofln("connected to team %s as %s", userTeam.TeamName, userTeam.SlackEmail)
-
-			portals := user.bridge.dbPortalsToPortals(user.bridge.DB.Portal.GetAllForUserTeam(userTeam.Key))
-			for _, portal := range portals {
-				err := portal.ForwardBackfill()
-				if err != nil {
-					user.log.Warnfln("Forward backfill for portal %s failed: %v", portal.Key, err)
-				}
+	for {
+		select {
+		case msg := <-source.Events():
+			if err := user.handleSlackEvent(userTeam, msg); err != nil {
+				return err
 			}
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateConnected})
-		case *slack.HelloEvent:
-			// Ignored for now
-		case *slack.InvalidAuthEvent:
-			user.log.Errorln("invalid authentication token")
-
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateBadCredentials})
-
-			// TODO: Should drop a message in the management room
-
-			return
-		case *slack.LatencyReport:
-			user.log.Debugln("latency report:", event.Value)
-		case *slack.MessageEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				if portal.MXID == "" {
-					channel, err := userTeam.Client.GetConversationInfo(&slack.GetConversationInfoInput{
-						ChannelID:         event.Channel,
-						IncludeLocale:     true,
-						IncludeNumMembers: true,
-					})
-					if err != nil {
-						portal.log.Errorln("failed to lookup channel info:", err)
-						continue
-					}
-
-					portal.log.Debugln("Creating Matrix room from incoming message")
-					if err := portal.CreateMatrixRoom(user, userTeam, channel, false); err != nil {
-						portal.log.Errorln("Failed to create portal room:", err)
-						continue
+		case <-source.Done():
+			// slack-go doesn't close the event channel, so handle anything that was sent before the source stopped
+			for {
+				select {
+				case msg := <-source.Events():
+					if err := user.handleSlackEvent(userTeam, msg); err != nil {
+						return err
 					}
+				default:
+					return errEventSourceStopped
 				}
-				portal.HandleSlackMessage(user, userTeam, event)
 			}
-		case *slack.ReactionAddedEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Item.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				portal.HandleSlackReaction(user, userTeam, event)
-			}
-		case *slack.ReactionRemovedEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Item.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				portal.HandleSlackReactionRemoved(user, userTeam, event)
-			}
-		case *slack.UserTypingEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				portal.HandleSlackTyping(user, userTeam, event)
-			}
-		case *slack.ChannelMarkedEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				portal.HandleSlackChannelMarked(user, userTeam, event)
-			}
-		case *slack.ChannelJoinedEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel.ID)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				if portal.MXID == "" {
-					portal.log.Debugln("Creating Matrix room from joined channel")
-					if err := portal.CreateMatrixRoom(user, userTeam, &event.Channel, false); err != nil {
-						portal.log.Errorln("Failed to create portal room:", err)
-						continue
-					}
+		}
+	}
+}
+
+// handleSlackEvent handles a single Slack event. An error is only returned if the connection can't be used anymore.
+func (user *User) handleSlackEvent(userTeam *database.UserTeam, msg slack.RTMEvent) error {
+	switch event := msg.Data.(type) {
+	case *slack.ConnectingEvent:
+		user.log.Debugfln("connecting: attempt %d", event.Attempt)
+		user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateConnecting})
+	case *slack.ConnectedEvent:
+		// Update all of our values according to what the server has for us.
+		userTeam.Key.SlackID = event.Info.User.ID
+		userTeam.Key.TeamID = event.Info.Team.ID
+		userTeam.TeamName = event.Info.Team.Name
+
+		userTeam.Upsert()
+
+		user.tryAutomaticDoublePuppeting(userTeam)
+
+		user.log.Infofln("connected to team %s as %s", userTeam.TeamName, userTeam.SlackEmail)
+
+		user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateConnected})
+		go user.forwardBackfillTeam(userTeam)
+		if event.ConnectionCount > 0 {
+			// slack-go reconnected by itself, so we may have missed changes to the team
+			go user.UpdateTeam(userTeam, false)
+		}
+	case *slack.HelloEvent:
+		// Ignored for now
+	case *slack.UserChangeEvent:
+		puppet := user.bridge.GetPuppetByID(userTeam.Key.TeamID, event.User.ID)
+		if puppet != nil {
+			puppet.UpdateInfo(userTeam, false, &event.User)
+		}
+	case *slack.PresenceChangeEvent:
+		user.handlePresenceChange(userTeam, event)
+	case *slack.ManualPresenceChangeEvent:
+		puppet := user.bridge.GetPuppetByID(userTeam.Key.TeamID, userTeam.Key.SlackID)
+		if puppet != nil {
+			puppet.UpdatePresence(event.Presence)
+		}
+	case *slack.InvalidAuthEvent:
+		user.log.Errorln("invalid authentication token")
+		return errInvalidAuth
+	case *slack.LatencyReport:
+		user.log.Debugln("latency report:", event.Value)
+	case *slack.MessageEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil && portal.Unbridged {
+			portal.log.Debugfln("Ignoring message %s in unbridged channel", event.Timestamp)
+		} else if portal != nil {
+			if portal.MXID == "" {
+				channel, err := userTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{
+					ChannelID:         event.Channel,
+					IncludeLocale:     true,
+					IncludeNumMembers: true,
+				})
+				if err != nil {
+					portal.log.Errorln("failed to lookup channel info:", err)
+					return nil
+				}
+
+				portal.log.Debugln("Creating Matrix room from incoming message")
+				if err := portal.CreateMatrixRoom(user, userTeam, channel, false); err != nil {
+					portal.log.Errorln("Failed to create portal room:", err)
+					return nil
 				}
-			} else {
-				portal.ensureUserInvited(user)
-			}
-		case *slack.ChannelLeftEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				portal.leave(userTeam)
-			}
-		case *slack.ChannelUpdateEvent:
-			key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
-			portal := user.bridge.GetPortalByID(key)
-			if portal != nil {
-				portal.UpdateInfo(user, userTeam, nil, true)
 			}
-		case *slack.RTMError:
-			user.log.Errorln("rtm error:", event.Error())
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: event.Error()})
-		case *slack.FileSharedEvent, *slack.FilePublicEvent, *slack.FilePrivateEvent, *slack.FileCreatedEvent, *slack.FileChangeEvent, *slack.FileDeletedEvent, *slack.DesktopNotificationEvent:
-			// ignored intentionally, these are duplicates or do not contain useful information
-		default:
-			user.log.Warnln("unknown message", msg)
+			portal.HandleSlackMessage(user, userTeam, event)
+		}
+	case *slack.ReactionAddedEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Item.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackReaction(user, userTeam, event)
+		}
+	case *slack.ReactionRemovedEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Item.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackReactionRemoved(user, userTeam, event)
+		}
+	case *slack.PinAddedEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackPin(event.Item, true)
+		}
+	case *slack.PinRemovedEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackPin(event.Item, false)
+		}
+	case *slack.UserTypingEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackTyping(user, userTeam, event)
+		}
+	case *slack.MemberJoinedChannelEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackMemberJoined(user, userTeam, event.User)
+		}
+	case *slack.MemberLeftChannelEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackMemberLeft(user, userTeam, event.User)
+		}
+	case *slack.ChannelMarkedEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackChannelMarked(user, userTeam, event)
+		}
+	case *slack.ChannelJoinedEvent:
+		user.handleConversationJoined(userTeam, event.Channel.ID, &event.Channel, "joined channel")
+	case *slack.GroupJoinedEvent:
+		user.handleConversationJoined(userTeam, event.Channel.ID, &event.Channel, "joined private channel")
+	case *MPIMJoinedEvent:
+		user.handleConversationJoined(userTeam, event.Channel.ID, &event.Channel, "joined group DM")
+	case *MPIMOpenEvent:
+		user.handleConversationJoined(userTeam, event.Channel, nil, "opened group DM")
+	case *slack.IMCreatedEvent:
+		user.handleConversationJoined(userTeam, event.Channel.ID, nil, "created DM")
+	case *slack.ChannelLeftEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.leave(userTeam)
+		}
+	case *slack.ChannelRenameEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel.ID)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackChannelRename(userTeam, event.Channel.Name)
+		}
+	case *slack.GroupRenameEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Group.ID)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.HandleSlackChannelRename(userTeam, event.Group.Name)
 		}
+	case *slack.ChannelArchiveEvent:
+		user.handleChannelArchive(userTeam, event.Channel, true)
+	case *slack.GroupArchiveEvent:
+		user.handleChannelArchive(userTeam, event.Channel, true)
+	case *slack.ChannelUnarchiveEvent:
+		user.handleChannelArchive(userTeam, event.Channel, false)
+	case *slack.GroupUnarchiveEvent:
+		user.handleChannelArchive(userTeam, event.Channel, false)
+	case *slack.ChannelDeletedEvent:
+		user.handleChannelDeleted(userTeam, event.Channel)
+	case *GroupDeletedEvent:
+		user.handleChannelDeleted(userTeam, event.Channel)
+	case *slack.EmojiChangedEvent:
+		user.bridge.handleEmojiChanged(userTeam, event)
+	case *slack.ChannelUpdateEvent:
+		key := database.NewPortalKey(userTeam.Key.TeamID, event.Channel)
+		portal := user.bridge.GetPortalByID(key)
+		if portal != nil {
+			portal.UpdateInfo(user, userTeam, nil, true)
+		}
+	case *slack.RTMError:
+		user.log.Errorln("rtm error:", event.Error())
+		user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: event.Error()})
+	case *slack.FileSharedEvent, *slack.FilePublicEvent, *slack.FilePrivateEvent, *slack.FileCreatedEvent, *slack.FileChangeEvent, *slack.FileDeletedEvent, *slack.DesktopNotificationEvent:
+		// ignored intentionally, these are duplicates or do not contain useful information
+	default:
+		user.log.Warnln("unknown message", msg)
 	}
-	user.log.Errorfln("Slack RTM for %s unexpectedly disconnected!", userTeam.Key)
-	user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: "Disconnected for unknown reason"})
+	return nil
 }
 
-func (user *User) connectTeam(userTeam *database.UserTeam) error {
+// connectTeam creates the Slack client of the user team and starts receiving its events.
+func (user *User) connectTeam(userTeam *database.UserTeam) (SlackEventSource, error) {
 	user.log.Infofln("Connecting %s to Slack userteam %s (%s)", user.MXID, userTeam.Key, userTeam.TeamName)
 	slackOptions := []slack.Option{
 		slack.OptionLog(SlackgoLogger{user.log.Sub(fmt.Sprintf("SlackGo/%s", userTeam.Key))}),
@@ -525,24 +622,25 @@ func (user *User) connectTeam(userTeam *database.UserTeam) error {
 	if userTeam.CookieToken != "" {
 		slackOptions = append(slackOptions, slack.OptionCookie("d", userTeam.CookieToken))
 	}
-	userTeam.Client = slack.New(userTeam.Token, slackOptions...)
+	client := slack.New(userTeam.Token, slackOptions...)
 
 	// test Slack connection before trying to go further
-	_, err := userTeam.Client.GetUserProfile(&slack.GetUserProfileParameters{})
+	_, err := client.GetUserProfile(&slack.GetUserProfileParameters{})
 	if err != nil {
 		user.log.Errorln("Error connecting to Slack team", err)
-		return err
+		return nil, err
 	}
+	userTeam.SetClient(client)
 
-	userTeam.RTM = userTeam.Client.NewRTM()
-
-	go userTeam.RTM.ManageConnection()
-
-	go user.slackMessageHandler(userTeam)
+	source, err := user.newEventSource(userTeam)
+	if err != nil {
+		user.log.Errorln("Error starting to receive Slack events", err)
+		return nil, err
+	}
 
 	go user.UpdateTeam(userTeam, false)
 
-	return nil
+	return source, nil
 }
 
 func (user *User) isChannelOrOpenIM(channel *slack.Channel) bool {
@@ -553,12 +651,50 @@ func (user *User) isChannelOrOpenIM(channel *slack.Channel) bool {
 	}
 }
 
+// handleConversationJoined makes sure the user has a portal for a conversation they were just added to.
+// If channel is nil, the conversation info will be fetched from Slack.
+func (user *User) handleConversationJoined(userTeam *database.UserTeam, channelID string, channel *slack.Channel, reason string) {
+	key := database.NewPortalKey(userTeam.Key.TeamID, channelID)
+	portal := user.bridge.GetPortalByID(key)
+	if portal == nil {
+		return
+	}
+	if portal.MXID == "" {
+		portal.log.Debugfln("Creating Matrix room from %s", reason)
+		if err := portal.CreateMatrixRoom(user, userTeam, channel, false); errors.Is(err, errChannelBeingBridged) {
+			portal.log.Debugln("Not creating portal room:", err)
+		} else if err != nil {
+			portal.log.Errorln("Failed to create portal room:", err)
+		}
+	} else {
+		portal.log.Debugfln("Inviting %s to existing portal from %s", user.MXID, reason)
+		portal.ensureUserInvited(user)
+		portal.InsertUser(userTeam.Key)
+	}
+}
+
+func (user *User) handleChannelArchive(userTeam *database.UserTeam, channelID string, archived bool) {
+	key := database.NewPortalKey(userTeam.Key.TeamID, channelID)
+	portal := user.bridge.GetPortalByID(key)
+	if portal != nil {
+		portal.HandleSlackChannelArchive(archived)
+	}
+}
+
+func (user *User) handleChannelDeleted(userTeam *database.UserTeam, channelID string) {
+	key := database.NewPortalKey(userTeam.Key.TeamID, channelID)
+	portal := user.bridge.GetPortalByID(key)
+	if portal != nil {
+		portal.HandleSlackChannelDeleted()
+	}
+}
+
 func (user *User) SyncPortals(userTeam *database.UserTeam, force bool) error {
 	channelInfo := map[string]slack.Channel{}
 
 	if !strings.HasPrefix(userTeam.Token, "xoxs") {
 		// TODO: use pagination to make sure we get everything!
-		channels, _, err := userTeam.Client.GetConversationsForUser(&slack.GetConversationsForUserParameters{
+		channels, _, err := userTeam.Client().GetConversationsForUser(&slack.GetConversationsForUserParameters{
 			Types: []string{"public_channel", "private_channel", "mpim", "im"},
 			Limit: user.bridge.Config.Bridge.Backfill.ConversationsCount,
 		})
@@ -567,7 +703,7 @@ func (user *User) SyncPortals(userTeam *database.UserTeam, force bool) error {
 		}
 		for i, channel := range channels {
 			// replace channel entry in list with one that has more metadata
-			c, err := userTeam.Client.GetConversationInfo(&slack.GetConversationInfoInput{
+			c, err := userTeam.Client().GetConversationInfo(&slack.GetConversationInfoInput{
 				ChannelID:         channel.ID,
 				IncludeLocale:     true,
 				IncludeNumMembers: true,
@@ -639,7 +775,7 @@ func (user *User) UpdateTeam(userTeam *database.UserTeam, force bool) error {
 		currentTeamInfo.TeamID = userTeam.Key.TeamID
 	}
 
-	teamInfo, err := userTeam.Client.GetTeamInfo()
+	teamInfo, err := userTeam.Client().GetTeamInfo()
 	if err != nil {
 		user.log.Errorfln("Error fetching info for team %s: %v", userTeam.Key.TeamID, err)
 		return err
@@ -674,7 +810,7 @@ func (user *User) UpdateTeam(userTeam *database.UserTeam, force bool) error {
 	}
 	currentTeamInfo.Upsert()
 
-	emojis, err := userTeam.Client.GetEmoji()
+	emojis, err := userTeam.Client().GetEmoji()
 	if err != nil {
 		user.log.Error("Fetching emojis for team failed", err)
 	} else {
@@ -693,6 +829,7 @@ func (user *User) UpdateTeam(userTeam *database.UserTeam, force bool) error {
 	for _, puppet := range puppets {
 		puppet.UpdateInfo(userTeam, false, nil)
 	}
+	user.subscribePresence(userTeam)
 	return user.SyncPortals(userTeam, changed || force)
 }
 
@@ -704,18 +841,7 @@ func (user *User) Connect() error {
 	for key, userTeam := range user.Teams {
 		user.bridge.usersByID[fmt.Sprintf("%s-%s", userTeam.Key.TeamID, userTeam.Key.SlackID)] = user
 		user.BridgeStates[key] = user.bridge.NewBridgeStateQueue(userTeam)
-		err := user.connectTeam(userTeam)
-		if err != nil && (err.Error() == "user_removed_from_team" || err.Error() == "invalid_auth") {
-			user.LogoutUserTeam(userTeam)
-			user.log.Infoln("User not logged in to Slack team, deleting")
-		} else if err != nil {
-			user.log.Errorln("Error connecting to Slack team", err)
-		}
-		// if err != nil {
-		// 	user.log.Errorfln("Error connecting to Slack userteam %s: %v", userTeam.Key, err)
-		// 	// TODO: more detailed error state
-		// 	user.BridgeStates[key].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: err.Error()})
-		// }
+		user.startTeamConnection(userTeam)
 	}
 
 	return nil
@@ -723,15 +849,13 @@ func (user *User) Connect() error {
 
 func (user *User) disconnectTeam(userTeam *database.UserTeam) error {
 	user.log.Infofln("Disconnecting Slack userteam %s", userTeam.Key)
-	if userTeam.RTM != nil {
-		if err := userTeam.RTM.Disconnect(); err != nil {
-			user.log.Errorfln("Error disconnecting RTM for %s: %v", userTeam.Key, err)
-			user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: err.Error()})
-			return err
-		}
+	if err := user.stopTeamConnection(userTeam.Key.TeamID); err != nil {
+		user.log.Errorfln("Error disconnecting events for %s: %v", userTeam.Key, err)
+		user.BridgeStates[userTeam.Key.TeamID].Send(status.BridgeState{StateEvent: status.StateUnknownError, Message: err.Error()})
+		return err
 	}
 
-	userTeam.Client = nil
+	userTeam.SetClient(nil)
 	user.log.Debugfln("Slack client for %s set to nil!", userTeam.Key)
 
 	return nil